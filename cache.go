@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+)
+
+// stateFileName is the cache kept in a batch output directory.
+const stateFileName = ".datatool-state.json"
+
+// convertState records, per source file, what produced the output currently
+// on disk so unchanged sources can be skipped on the next batch run.
+type convertState struct {
+	Files map[string]cacheEntry `json:"files"`
+}
+
+type cacheEntry struct {
+	SourceHash  string `json:"source_hash"`
+	ToolVersion string `json:"tool_version"`
+	OptionsHash string `json:"options_hash"`
+	Output      string `json:"output"`
+	OutputHash  string `json:"output_hash"`
+}
+
+// loadState reads the state file at path. A missing or unreadable file gives
+// an empty state, which only costs a full conversion.
+func loadState(path string) *convertState {
+	s := &convertState{Files: make(map[string]cacheEntry)}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return s
+	}
+	if json.Unmarshal(b, s) != nil || s.Files == nil {
+		return &convertState{Files: make(map[string]cacheEntry)}
+	}
+	return s
+}
+
+func (s *convertState) save(path string) error {
+	return writeFileAtomic(path, false, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	})
+}
+
+// upToDate reports whether the recorded conversion of src is still valid:
+// the same source, tool and options produced an output that has not been
+// touched since.
+func (s *convertState) upToDate(src string, want cacheEntry) bool {
+	got, ok := s.Files[src]
+	if !ok || got.SourceHash != want.SourceHash || got.ToolVersion != want.ToolVersion ||
+		got.OptionsHash != want.OptionsHash || got.Output != want.Output {
+		return false
+	}
+	outHash, err := hashFile(got.Output)
+	return err == nil && outHash == got.OutputHash
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", fmt.Errorf("hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hash identifies the settings that affect the bytes written for one file.
+func (o convertOptions) hash() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "from=%s\nto=%s\nindent=%q\norder=%s\n",
+		o.from, o.to, o.style.Indent, strings.Join(o.style.AttributeOrder, ","))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// toolVersion identifies the build of data-tool, so upgrading it invalidates
+// cached outputs.
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	v := info.Main.Version
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			v += "+" + s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				v += "-dirty"
+			}
+		}
+	}
+	return v
+}
+
+// cachedConvert converts inFile to outFile unless state shows the output is
+// already current, updating state on success. A skipped file has status
+// statusUnchanged.
+func cachedConvert(state *convertState, inFile, outFile string, opts convertOptions) (convertResult, error) {
+	res := convertResult{from: opts.from, to: opts.to}
+	key, err := filepath.Abs(inFile)
+	if err != nil {
+		return res, fmt.Errorf("filepath.Abs: %w", err)
+	}
+	key = filepath.ToSlash(key)
+	srcHash, err := hashFile(inFile)
+	if err != nil {
+		return res, fmt.Errorf("hash source: %w", err)
+	}
+	entry := cacheEntry{
+		SourceHash:  srcHash,
+		ToolVersion: toolVersion(),
+		OptionsHash: opts.hash(),
+		Output:      outFile,
+	}
+	if !opts.force && state.upToDate(key, entry) {
+		res.status = statusUnchanged
+		return res, nil
+	}
+
+	delete(state.Files, key)
+	res, err = convert(inFile, outFile, opts)
+	if err != nil {
+		return res, err
+	}
+	entry.OutputHash, err = hashFile(outFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return res, fmt.Errorf("hash output: %w", err)
+	}
+	state.Files[key] = entry
+	return res, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/7daystosettle/data-tool/ko"
+)
+
+func TestCachedConvert(t *testing.T) {
+	dir := t.TempDir()
+	inFile := filepath.Join(dir, "items.kdl")
+	outFile := filepath.Join(dir, "items.xml")
+	statePath := filepath.Join(dir, stateFileName)
+	if err := os.WriteFile(inFile, []byte("items {\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	step := func(name string, opts convertOptions, wantSkipped bool) {
+		t.Helper()
+		state := loadState(statePath)
+		res, err := cachedConvert(state, inFile, outFile, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if skipped := res.status == statusUnchanged; skipped != wantSkipped {
+			t.Errorf("%s: skipped = %v, want %v", name, skipped, wantSkipped)
+		}
+		if err := state.save(statePath); err != nil {
+			t.Fatalf("%s: save: %v", name, err)
+		}
+	}
+
+	step("first run", convertOptions{}, false)
+	step("unchanged", convertOptions{}, true)
+	step("forced", convertOptions{force: true}, false)
+	step("options changed", convertOptions{style: ko.Options{Indent: "\t"}}, false)
+
+	if err := os.WriteFile(inFile, []byte("items {\n  item name=\"a\"\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	step("source changed", convertOptions{style: ko.Options{Indent: "\t"}}, false)
+
+	if err := os.WriteFile(outFile, []byte("hand edited"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	step("output edited", convertOptions{style: ko.Options{Indent: "\t"}}, false)
+	step("unchanged again", convertOptions{style: ko.Options{Indent: "\t"}}, true)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/7daystosettle/data-tool/changelog"
+	"github.com/7daystosettle/data-tool/ko"
+	"github.com/7daystosettle/data-tool/project"
+)
+
+func runChangelog(args []string) error {
+	fs := flag.NewFlagSet("changelog", flag.ContinueOnError)
+	configPath := fs.String("config", "", "project file with extra identity rules (default: "+project.FileName+" in the working directory or a parent)")
+	format := fs.String("format", "", "markdown or html (default: from the --out extension, else markdown)")
+	out := fs.String("out", "", "file to write (default: stdout)")
+	title := fs.String("title", "Config changelog", "heading of the changelog")
+	oldLabel := fs.String("old-label", "", "name of the old version (default: the old directory)")
+	newLabel := fs.String("new-label", "", "name of the new version (default: the new directory)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s changelog [flags] <old-config-dir> <new-config-dir>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errUsage
+	}
+
+	render, err := changelogRenderer(*format, *out)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadProject(*configPath)
+	if err != nil {
+		return err
+	}
+	reg := registryFor(cfg)
+
+	oldSet, err := dirConfigSet(fs.Arg(0), reg)
+	if err != nil {
+		return err
+	}
+	newSet, err := dirConfigSet(fs.Arg(1), reg)
+	if err != nil {
+		return err
+	}
+	cl, err := diffConfigSets(oldSet, newSet)
+	if err != nil {
+		return err
+	}
+	cl.Title = *title
+	cl.Old, cl.New = labelOr(*oldLabel, fs.Arg(0)), labelOr(*newLabel, fs.Arg(1))
+
+	if *out == "" {
+		return render(os.Stdout, cl)
+	}
+	return writeFileAtomic(*out, false, func(w io.Writer) error {
+		return render(w, cl)
+	})
+}
+
+func changelogRenderer(format, out string) (func(io.Writer, *changelog.Changelog) error, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(out)) {
+		case ".html", ".htm":
+			format = "html"
+		default:
+			format = "markdown"
+		}
+	}
+	switch format {
+	case "markdown", "md":
+		return changelog.RenderMarkdown, nil
+	case "html":
+		return changelog.RenderHTML, nil
+	default:
+		return nil, fmt.Errorf("--format: unknown format %q, want markdown or html", format)
+	}
+}
+
+func labelOr(label, dir string) string {
+	if label != "" {
+		return label
+	}
+	return filepath.Base(filepath.Clean(dir))
+}
+
+// configFile is one config file of a set, loaded on demand.
+type configFile struct {
+	// name is the file name shown to people, such as items.xml.
+	name string
+	load func() (*ko.Ko, error)
+}
+
+// configSet holds the config files of one version keyed by base name without
+// extension, so items.xml in one version matches items.kdl in another.
+type configSet map[string]configFile
+
+// dirConfigSet returns the XML and KDL files directly inside dir.
+func dirConfigSet(dir string, reg *ko.Registry) (configSet, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read config dir: %w", err)
+	}
+	set := make(configSet)
+	for _, e := range entries {
+		if e.IsDir() || formatFromPath(e.Name()) == "" {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		base := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		if _, dup := set[base]; dup && formatFromPath(e.Name()) != formatXml {
+			continue // the XML wins when both forms are present
+		}
+		set[base] = configFile{name: e.Name(), load: func() (*ko.Ko, error) {
+			return loadFile(path, reg)
+		}}
+	}
+	return set, nil
+}
+
+// diffConfigSets diffs every file present in either set.
+func diffConfigSets(old, new configSet) (*changelog.Changelog, error) {
+	names := make([]string, 0, len(old)+len(new))
+	for base := range old {
+		names = append(names, base)
+	}
+	for base := range new {
+		if _, ok := old[base]; !ok {
+			names = append(names, base)
+		}
+	}
+	sort.Strings(names)
+
+	empty, err := ko.NewFromKdl(strings.NewReader(""))
+	if err != nil {
+		return nil, err
+	}
+	cl := &changelog.Changelog{}
+	for _, base := range names {
+		of, inOld := old[base]
+		nf, inNew := new[base]
+		f := changelog.File{Kind: changelog.FileChanged, Name: nf.name}
+
+		oldDoc, newDoc := empty, empty
+		if inOld {
+			oldDoc, err = of.load()
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			f.Kind = changelog.FileAdded
+		}
+		if inNew {
+			newDoc, err = nf.load()
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			f.Kind, f.Name = changelog.FileRemoved, of.name
+		}
+
+		f.Entities = ko.Diff(oldDoc, newDoc)
+		if len(f.Entities) > 0 || f.Kind != changelog.FileChanged {
+			cl.Files = append(cl.Files, f)
+		}
+	}
+	return cl, nil
+}
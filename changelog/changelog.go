@@ -0,0 +1,168 @@
+// Package changelog renders the semantic differences between two sets of
+// config files, such as two game builds, as Markdown or self-contained HTML
+// patch notes.
+package changelog
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/7daystosettle/data-tool/ko"
+)
+
+// Kinds of File change.
+const (
+	FileAdded   = "added"
+	FileRemoved = "removed"
+	FileChanged = "changed"
+)
+
+// Changelog is the set of changes between two versions.
+type Changelog struct {
+	Title string
+	// Old and New label the versions, such as "V1.2 b27".
+	Old   string
+	New   string
+	Files []File
+}
+
+// File is the changes to one config file.
+type File struct {
+	// Name is the file name without directory, such as items.xml.
+	Name     string
+	Kind     string
+	Entities []ko.EntityChange
+}
+
+// Counts tallies entity changes by kind.
+type Counts struct {
+	Added   int
+	Removed int
+	Changed int
+}
+
+// Counts returns the entity change totals over all files.
+func (c *Changelog) Counts() Counts {
+	var n Counts
+	for _, f := range c.Files {
+		fc := f.Counts()
+		n.Added += fc.Added
+		n.Removed += fc.Removed
+		n.Changed += fc.Changed
+	}
+	return n
+}
+
+// Counts returns the entity change totals for the file.
+func (f *File) Counts() Counts {
+	var n Counts
+	for _, e := range f.Entities {
+		switch e.Kind {
+		case ko.ChangeAdded:
+			n.Added++
+		case ko.ChangeRemoved:
+			n.Removed++
+		default:
+			n.Changed++
+		}
+	}
+	return n
+}
+
+// byKind returns the file's entity changes of one kind.
+func (f *File) byKind(kind string) []ko.EntityChange {
+	var out []ko.EntityChange
+	for _, e := range f.Entities {
+		if e.Kind == kind {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// summary is the one-line total of c, such as "2 files changed: 1 entity
+// added, 3 removed, 1 changed."
+func (c *Changelog) summary() string {
+	n := c.Counts()
+	return fmt.Sprintf("%d %s changed: %d %s added, %d removed, %d changed.",
+		len(c.Files), plural(len(c.Files), "file", "files"), n.Added, plural(n.Added, "entity", "entities"), n.Removed, n.Changed)
+}
+
+// plural returns one when n is 1 and many otherwise.
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// RenderMarkdown writes c as Markdown: a section per file listing added,
+// removed and changed entities, with each changed property's old and new
+// value.
+func RenderMarkdown(w io.Writer, c *Changelog) error {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "# %s\n\n", c.Title)
+	if c.Old != "" || c.New != "" {
+		fmt.Fprintf(b, "%s → %s\n\n", mdCode(c.Old), mdCode(c.New))
+	}
+	fmt.Fprintf(b, "%s\n", c.summary())
+
+	for _, f := range c.Files {
+		fmt.Fprintf(b, "\n## %s\n", f.Name)
+		switch f.Kind {
+		case FileAdded:
+			b.WriteString("\nNew file.\n")
+		case FileRemoved:
+			b.WriteString("\nFile removed.\n")
+		}
+		writeMarkdownSection(b, "Added", f.byKind(ko.ChangeAdded), false)
+		writeMarkdownSection(b, "Removed", f.byKind(ko.ChangeRemoved), false)
+		writeMarkdownSection(b, "Changed", f.byKind(ko.ChangeModified), true)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	if err != nil {
+		return fmt.Errorf("write markdown: %w", err)
+	}
+	return nil
+}
+
+func writeMarkdownSection(b *strings.Builder, title string, entities []ko.EntityChange, properties bool) {
+	if len(entities) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n### %s\n\n", title)
+	for _, e := range entities {
+		fmt.Fprintf(b, "- %s %s\n", e.Type, mdCode(e.Key))
+		if !properties {
+			continue
+		}
+		for _, p := range e.Properties {
+			switch p.Kind {
+			case ko.ChangeAdded:
+				fmt.Fprintf(b, "  - %s added: %s\n", mdCode(p.Name), mdCode(p.New))
+			case ko.ChangeRemoved:
+				fmt.Fprintf(b, "  - %s removed (was %s)\n", mdCode(p.Name), mdCode(p.Old))
+			default:
+				fmt.Fprintf(b, "  - %s: %s → %s\n", mdCode(p.Name), mdCode(p.Old), mdCode(p.New))
+			}
+		}
+	}
+}
+
+// mdCode renders s as an inline code span, using a fence longer than any run
+// of backticks inside it.
+func mdCode(s string) string {
+	if s == "" {
+		return `""`
+	}
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+	return fence + s + fence
+}
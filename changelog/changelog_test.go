@@ -0,0 +1,62 @@
+package changelog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/7daystosettle/data-tool/ko"
+)
+
+func sample() *Changelog {
+	return &Changelog{
+		Title: "Patch notes",
+		Old:   "A21",
+		New:   "A22",
+		Files: []File{
+			{Name: "items.xml", Kind: FileChanged, Entities: []ko.EntityChange{
+				{Kind: ko.ChangeAdded, Type: "item", Key: "newGun"},
+				{Kind: ko.ChangeModified, Type: "item", Key: "gunPistol", Properties: []ko.PropertyChange{
+					{Kind: ko.ChangeModified, Name: "EntityDamage", Old: "30", New: "<32>"},
+				}},
+			}},
+			{Name: "blocks.xml", Kind: FileRemoved, Entities: []ko.EntityChange{
+				{Kind: ko.ChangeRemoved, Type: "block", Key: "oldBlock"},
+			}},
+		},
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	var b bytes.Buffer
+	if err := RenderMarkdown(&b, sample()); err != nil {
+		t.Fatalf("RenderMarkdown: %v", err)
+	}
+	for _, want := range []string{
+		"# Patch notes\n",
+		"2 files changed: 1 entity added, 1 removed, 1 changed.",
+		"## items.xml\n\n### Added\n\n- item `newGun`\n",
+		"- item `gunPistol`\n  - `EntityDamage`: `30` → `<32>`\n",
+		"## blocks.xml\n\nFile removed.\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("markdown missing %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	var b bytes.Buffer
+	if err := RenderHTML(&b, sample()); err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	out := b.String()
+	for _, want := range []string{"<title>Patch notes</title>", "<p>2 files changed: 1 entity added, 1 removed, 1 changed.</p>", "gunPistol", "&lt;32&gt;", "<style>"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("html missing %q", want)
+		}
+	}
+	if strings.Contains(out, "<32>") {
+		t.Error("html does not escape values")
+	}
+}
@@ -0,0 +1,70 @@
+package changelog
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+
+	"github.com/7daystosettle/data-tool/ko"
+)
+
+var htmlTemplate = template.Must(template.New("changelog").Funcs(template.FuncMap{
+	"byKind":  func(f File, kind string) []ko.EntityChange { return f.byKind(kind) },
+	"counts":  func(f File) Counts { return f.Counts() },
+	"summary": func(c *Changelog) string { return c.summary() },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em auto; max-width: 60em; color: #222; }
+h1 { margin-bottom: 0.2em; }
+.versions { color: #666; margin-top: 0; }
+details { border: 1px solid #ddd; border-radius: 4px; margin: 0.5em 0; padding: 0.3em 0.8em; }
+summary { cursor: pointer; font-weight: bold; }
+.count { font-weight: normal; color: #666; }
+h3 { font-size: 1em; margin: 1em 0 0.3em; }
+ul { margin: 0.2em 0; }
+code { background: #f4f4f4; padding: 0 0.2em; border-radius: 2px; }
+.added { color: #1a7f37; }
+.removed { color: #cf222e; }
+table { border-collapse: collapse; margin: 0.2em 0 0.6em 1.5em; }
+td, th { border: 1px solid #ddd; padding: 0.1em 0.5em; text-align: left; vertical-align: top; }
+th { background: #f8f8f8; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if or .Old .New}}<p class="versions">{{.Old}} → {{.New}}</p>{{end}}
+<p>{{summary .}}</p>
+{{range .Files}}
+<details open>
+<summary>{{.Name}} {{with counts .}}<span class="count">+{{.Added}} −{{.Removed}} ~{{.Changed}}</span>{{end}}</summary>
+{{if eq .Kind "added"}}<p>New file.</p>{{else if eq .Kind "removed"}}<p>File removed.</p>{{end}}
+{{with byKind . "added"}}<h3 class="added">Added</h3>
+<ul>{{range .}}<li>{{.Type}} <code>{{.Key}}</code></li>{{end}}</ul>{{end}}
+{{with byKind . "removed"}}<h3 class="removed">Removed</h3>
+<ul>{{range .}}<li>{{.Type}} <code>{{.Key}}</code></li>{{end}}</ul>{{end}}
+{{with byKind . "changed"}}<h3>Changed</h3>
+{{range .}}<p>{{.Type}} <code>{{.Key}}</code></p>
+<table>
+<tr><th>Property</th><th>Old</th><th>New</th></tr>
+{{range .Properties}}<tr><td><code>{{.Name}}</code></td><td class="removed">{{if ne .Kind "added"}}<code>{{.Old}}</code>{{end}}</td><td class="added">{{if ne .Kind "removed"}}<code>{{.New}}</code>{{end}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}
+</details>
+{{end}}
+</body>
+</html>
+`))
+
+// RenderHTML writes c as a single HTML page with its styles inline, so it can
+// be shared or attached without other files.
+func RenderHTML(w io.Writer, c *Changelog) error {
+	err := htmlTemplate.Execute(w, c)
+	if err != nil {
+		return fmt.Errorf("render html: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/7daystosettle/data-tool/ko"
+	"github.com/7daystosettle/data-tool/project"
+)
+
+const (
+	formatXml = "xml"
+	formatKdl = "kdl"
+
+	// stdioPath stands for stdin when used as an input path and stdout when
+	// used as an output path.
+	stdioPath = "-"
+)
+
+// convertFlags are the flags shared by every command that converts files.
+type convertFlags struct {
+	config   *string
+	from     *string
+	to       *string
+	indent   *int
+	profile  *string
+	existing *string
+	backup   *bool
+}
+
+func addConvertFlags(fs *flag.FlagSet) *convertFlags {
+	return &convertFlags{
+		config:   fs.String("config", "", "project file (default: "+project.FileName+" in the working directory or a parent)"),
+		from:     fs.String("from", "", "input format, xml or kdl (default: from the input extension, or sniffed on stdin)"),
+		to:       fs.String("to", "", "output format, xml or kdl (default: from the output extension, else the other format)"),
+		indent:   fs.Int("indent", -1, "spaces per nesting level (default 2)"),
+		profile:  fs.String("profile", "", "attribute ordering profile from the project file"),
+		existing: fs.String("existing", existingOverwrite, "what to do when an output file exists: overwrite, skip-existing or fail-if-exists"),
+		backup:   fs.Bool("backup", false, "keep an overwritten output as <file>.bak"),
+	}
+}
+
+// convertOptions are the settings for one conversion run after the project
+// file and flags have been combined.
+type convertOptions struct {
+	from    string
+	to      string
+	style   ko.Options
+	project *project.Config
+	// profileSet records that --profile was given, so it beats the
+	// per-file profiles from the project file.
+	profileSet bool
+	// existing is the policy for outputs that already exist.
+	existing string
+	// backup keeps overwritten outputs as <file>.bak.
+	backup bool
+	// force reconverts batch files even when the cache says they are
+	// current.
+	force bool
+	// report, when set, collects a fileReport for every file converted.
+	report *runReport
+	// log receives progress messages; nil means stdout.
+	log io.Writer
+}
+
+// logWriter returns where progress messages go, keeping stdout free for a
+// report when one was asked for.
+func (o convertOptions) logWriter() io.Writer {
+	if o.log == nil {
+		return os.Stdout
+	}
+	return o.log
+}
+
+func (f *convertFlags) options() (convertOptions, error) {
+	var opts convertOptions
+
+	cfg, err := loadProject(*f.config)
+	if err != nil {
+		return opts, err
+	}
+	opts.project = cfg
+
+	switch *f.existing {
+	case existingOverwrite, existingSkip, existingFail:
+		opts.existing = *f.existing
+	default:
+		return opts, fmt.Errorf("--existing: unknown policy %q, want overwrite, skip-existing or fail-if-exists", *f.existing)
+	}
+	opts.backup = *f.backup
+
+	opts.from, err = parseFormat(*f.from)
+	if err != nil {
+		return opts, fmt.Errorf("--from: %w", err)
+	}
+	to := *f.to
+	if to == "" {
+		to = cfg.To
+	}
+	opts.to, err = parseFormat(to)
+	if err != nil {
+		return opts, fmt.Errorf("--to: %w", err)
+	}
+
+	profile := cfg.Profile
+	if *f.profile != "" {
+		profile = *f.profile
+		opts.profileSet = true
+	}
+	if profile != "" {
+		order, ok := cfg.Profiles[profile]
+		if !ok {
+			return opts, fmt.Errorf("unknown ordering profile %q", profile)
+		}
+		opts.style.AttributeOrder = order
+	}
+
+	indent := cfg.Indent
+	if *f.indent >= 0 {
+		indent = *f.indent
+	}
+	if indent > 0 {
+		opts.style.Indent = strings.Repeat(" ", indent)
+	}
+	return opts, nil
+}
+
+// forSource returns the output path, relative to the output directory, and
+// style for the file at rel inside the batch source directory srcDir,
+// applying any per-file mapping from the project file.
+func (o convertOptions) forSource(srcDir, rel, target string) (string, ko.Options) {
+	outRel := strings.TrimSuffix(rel, filepath.Ext(rel)) + "." + target
+	style := o.style
+	if o.project.Source == "" || !samePath(srcDir, o.project.Source) {
+		return outRel, style
+	}
+	m := o.project.Mapping(rel)
+	if m == nil {
+		return outRel, style
+	}
+	if m.Output != "" {
+		outRel = m.Output
+	}
+	if m.Profile != "" && !o.profileSet {
+		style.AttributeOrder = o.project.Profiles[m.Profile]
+	}
+	return outRel, style
+}
+
+// loadProject loads the project file at path, or the one found by searching
+// upward from the working directory when path is empty. Without a project
+// file it returns an empty config.
+func loadProject(path string) (*project.Config, error) {
+	if path == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("getwd: %w", err)
+		}
+		path, err = project.Find(wd)
+		if err != nil {
+			return nil, fmt.Errorf("find project file: %w", err)
+		}
+		if path == "" {
+			return &project.Config{}, nil
+		}
+	}
+	cfg, err := project.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("load project file: %w", err)
+	}
+	return cfg, nil
+}
+
+func samePath(a, b string) bool {
+	a, errA := filepath.Abs(a)
+	b, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && a == b
+}
+
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	flags := addConvertFlags(fs)
+	force := fs.Bool("force", false, "reconvert every file in a directory, ignoring the cache of unchanged files")
+	reportFormat := fs.String("report", "", "write a machine-readable run report; json is the only format")
+	reportOut := fs.String("report-out", "", "file to write the report to (default: stdout, or stderr when converting to stdout)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s convert [flags] [src_path|- [out_path|-]]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() > 2 {
+		fs.Usage()
+		return errUsage
+	}
+
+	opts, err := flags.options()
+	if err != nil {
+		return err
+	}
+	opts.force = *force
+	switch *reportFormat {
+	case "":
+	case "json":
+		opts.report = newRunReport()
+		opts.log = os.Stderr
+	default:
+		return fmt.Errorf("--report: unknown format %q, want json", *reportFormat)
+	}
+
+	inPath := opts.project.Source
+	if fs.NArg() > 0 {
+		inPath = fs.Arg(0)
+	}
+	if inPath == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	if inPath != stdioPath {
+		info, err := os.Stat(inPath)
+		if err != nil {
+			return fmt.Errorf("stat input path: %w", err)
+		}
+		if info.IsDir() {
+			outPath := opts.project.Output
+			if fs.NArg() == 2 {
+				outPath = fs.Arg(1)
+			}
+			if outPath == "" || outPath == stdioPath {
+				return fmt.Errorf("converting a directory requires an output directory")
+			}
+			err = convertDir(inPath, outPath, opts)
+			if err != nil || opts.report == nil {
+				return err
+			}
+			return writeReport(opts.report, *reportOut, outPath)
+		}
+	}
+
+	outPath := stdioPath
+	if fs.NArg() == 2 {
+		outPath = fs.Arg(1)
+	}
+	start := time.Now()
+	res, err := convert(inPath, outPath, opts)
+	if opts.report != nil {
+		opts.report.add(inPath, outPath, res, err, time.Since(start))
+		return writeReport(opts.report, *reportOut, outPath)
+	}
+	if errors.Is(err, errSkipped) {
+		fmt.Fprintf(os.Stderr, "Skipped %s: %v\n", outPath, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("convert: %w", err)
+	}
+	return nil
+}
+
+// writeReport writes report to reportOut, or when that is empty to stdout,
+// unless stdout is already carrying the converted document.
+func writeReport(report *runReport, reportOut, outPath string) error {
+	if reportOut == "" {
+		if outPath == stdioPath {
+			return report.write(os.Stderr)
+		}
+		return report.write(os.Stdout)
+	}
+	return writeFileAtomic(reportOut, false, report.write)
+}
+
+func convertDir(inPath, outPath string, opts convertOptions) error {
+	start := time.Now()
+	totalConverted := 0
+	totalSkipped := 0
+	var unchanged []string
+
+	files, err := os.ReadDir(inPath)
+	if err != nil {
+		return fmt.Errorf("read input dir: %w", err)
+	}
+	err = os.MkdirAll(outPath, 0o755)
+	if err != nil {
+		return fmt.Errorf("create output dir: %w", err)
+	}
+	statePath := filepath.Join(outPath, stateFileName)
+	state := loadState(statePath)
+	log := opts.logWriter()
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		format := formatFromPath(file.Name())
+		if format == "" || (opts.from != "" && format != opts.from) {
+			continue
+		}
+		target := opts.to
+		if target == "" {
+			target = otherFormat(format)
+		}
+		inFile := filepath.Join(inPath, file.Name())
+		outRel, style := opts.forSource(inPath, file.Name(), target)
+		outFile := filepath.Join(outPath, outRel)
+		fileOpts := opts
+		fileOpts.from, fileOpts.to, fileOpts.style = format, formatFromPath(outFile), style
+		fileStart := time.Now()
+		var res convertResult
+		err := os.MkdirAll(filepath.Dir(outFile), 0o755)
+		if err == nil {
+			res, err = cachedConvert(state, inFile, outFile, fileOpts)
+		}
+		if opts.report != nil {
+			opts.report.add(inFile, outFile, res, err, time.Since(fileStart))
+		}
+		if res.status == statusUnchanged {
+			unchanged = append(unchanged, file.Name())
+			continue
+		}
+		if errors.Is(err, errSkipped) {
+			totalSkipped++
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(log, "Failed to convert %s: %v\n", inFile, err)
+		}
+
+		totalConverted++
+	}
+
+	fmt.Fprintf(log, "Converted %d files in %0.2f seconds\n", totalConverted, time.Since(start).Seconds())
+	if totalSkipped > 0 {
+		fmt.Fprintf(log, "Skipped %d files whose output already exists\n", totalSkipped)
+	}
+	if len(unchanged) > 0 {
+		fmt.Fprintf(log, "Skipped %d unchanged files (--force to reconvert):\n", len(unchanged))
+		for _, name := range unchanged {
+			fmt.Fprintf(log, "  %s\n", name)
+		}
+	}
+
+	err = state.save(statePath)
+	if err != nil {
+		return fmt.Errorf("save conversion cache: %w", err)
+	}
+
+	return nil
+}
+
+// convert reads inPath and writes it to outPath. Either path may be
+// stdioPath. Empty formats are inferred from the file extensions, or by
+// sniffing stdin; an output format that cannot be inferred is the opposite
+// of the input format. It returns errSkipped when opts.existing says to leave
+// an existing output alone.
+func convert(inPath, outPath string, opts convertOptions) (convertResult, error) {
+	res := convertResult{from: opts.from, to: opts.to}
+	if outPath != stdioPath {
+		err := checkExisting(outPath, opts.existing)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	var r io.Reader = os.Stdin
+	if inPath != stdioPath {
+		if res.from == "" {
+			res.from = formatFromPath(inPath)
+			if res.from == "" {
+				return res, fmt.Errorf("unsupported input file extension: %s", filepath.Ext(inPath))
+			}
+		}
+		f, err := os.Open(inPath)
+		if err != nil {
+			return res, fmt.Errorf("open file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	} else if res.from == "" {
+		br := bufio.NewReader(os.Stdin)
+		format, err := sniffFormat(br)
+		if err != nil {
+			return res, fmt.Errorf("sniff stdin format: %w", err)
+		}
+		res.from = format
+		r = br
+	}
+
+	if res.to == "" {
+		if outPath != stdioPath {
+			res.to = formatFromPath(outPath)
+		}
+		if res.to == "" {
+			res.to = otherFormat(res.from)
+		}
+	}
+
+	cr := &countingReader{r: r}
+	doc, err := readDoc(cr, res.from)
+	res.inputBytes = cr.n
+	if err != nil {
+		return res, err
+	}
+	doc.SetOptions(opts.style)
+	res.stats = doc.Stats()
+
+	write := func(w io.Writer) error {
+		cw := &countingWriter{w: w}
+		err := writeDoc(doc, cw, res.to)
+		res.outputBytes = cw.n
+		return err
+	}
+	if outPath == stdioPath {
+		err = write(os.Stdout)
+	} else {
+		err = writeFileAtomic(outPath, opts.backup, write)
+	}
+	if err != nil {
+		return res, err
+	}
+	res.status = statusConverted
+	return res, nil
+}
+
+// registryFor returns the built-in identity rules extended by those in the
+// project file.
+func registryFor(cfg *project.Config) *ko.Registry {
+	r := ko.DefaultRegistry()
+	for _, id := range cfg.Identities {
+		r.Add(ko.IdentityRule{File: id.File, Path: id.Path, Keys: id.Keys})
+	}
+	return r
+}
+
+// loadFile parses the XML or KDL file at path, choosing the format by
+// extension and falling back to sniffing the content. Its elements are
+// identified by reg.
+func loadFile(path string, reg *ko.Registry) (*ko.Ko, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	format := formatFromPath(path)
+	if format == "" {
+		format, err = sniffFormat(br)
+		if err != nil {
+			return nil, fmt.Errorf("sniff %s: %w", path, err)
+		}
+	}
+	doc, err := readDoc(br, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	doc.SetSource(path)
+	doc.SetRegistry(reg)
+	return doc, nil
+}
+
+// readDoc parses r as the given format.
+func readDoc(r io.Reader, format string) (*ko.Ko, error) {
+	switch format {
+	case formatXml:
+		doc, err := ko.NewFromXml(r)
+		if err != nil {
+			return nil, fmt.Errorf("parsing xml: %w", err)
+		}
+		return doc, nil
+	case formatKdl:
+		doc, err := ko.NewFromKdl(r)
+		if err != nil {
+			return nil, fmt.Errorf("parsing kdl: %w", err)
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unsupported input format: %s", format)
+	}
+}
+
+// writeDoc encodes doc to w in the given format.
+func writeDoc(doc *ko.Ko, w io.Writer, format string) error {
+	switch format {
+	case formatXml:
+		err := doc.ToXml(w)
+		if err != nil {
+			return fmt.Errorf("writing xml: %w", err)
+		}
+	case formatKdl:
+		err := doc.ToKdl(w)
+		if err != nil {
+			return fmt.Errorf("writing kdl: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+	return nil
+}
+
+func parseFormat(s string) (string, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return "", nil
+	case formatXml:
+		return formatXml, nil
+	case formatKdl:
+		return formatKdl, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, want xml or kdl", s)
+	}
+}
+
+// sniffFormat guesses the format of br from its first non-blank byte without
+// consuming it: XML always starts with '<', KDL never does.
+func sniffFormat(br *bufio.Reader) (string, error) {
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return "", fmt.Errorf("empty input")
+		}
+		if err != nil {
+			return "", err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = br.ReadByte()
+			continue
+		case '<':
+			return formatXml, nil
+		case 0xEF:
+			// UTF-8 byte order mark.
+			bom, err := br.Peek(3)
+			if err == nil && string(bom) == "\xEF\xBB\xBF" {
+				_, _ = br.Discard(3)
+				continue
+			}
+		}
+		return formatKdl, nil
+	}
+}
+
+// formatFromPath returns the format implied by the extension of p, or "" if
+// it is not one we convert.
+func formatFromPath(p string) string {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".xml":
+		return formatXml
+	case ".kdl":
+		return formatKdl
+	}
+	return ""
+}
+
+func otherFormat(format string) string {
+	if format == formatXml {
+		return formatKdl
+	}
+	return formatXml
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sampleXml = `<?xml version="1.0" encoding="UTF-8"?>
+<items>
+	<item name="gunPistol">
+		<property name="Tags" value="gun,pistol"/>
+	</item>
+</items>`
+
+func TestConvertStdio(t *testing.T) {
+	dir := t.TempDir()
+	inFile := filepath.Join(dir, "items.xml")
+	if err := os.WriteFile(inFile, []byte(sampleXml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdin, stdout := os.Stdin, os.Stdout
+	defer func() { os.Stdin, os.Stdout = stdin, stdout }()
+
+	in, err := os.Open(inFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdin, os.Stdout = in, w
+
+	err = runConvert([]string{"--to", "kdl", "-"})
+	w.Close()
+	if err != nil {
+		t.Fatalf("runConvert: %v", err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), `item name="gunPistol" {`) {
+		t.Errorf("unexpected kdl output:\n%s", out)
+	}
+}
+
+func TestSniffFormat(t *testing.T) {
+	cases := map[string]string{
+		"  \n<?xml version=\"1.0\"?><a/>": formatXml,
+		"\xEF\xBB\xBF<items/>":            formatXml,
+		"items {\n}\n":                    formatKdl,
+	}
+	for in, want := range cases {
+		got, err := sniffFormat(bufio.NewReader(strings.NewReader(in)))
+		if err != nil {
+			t.Fatalf("sniffFormat(%q): %v", in, err)
+		}
+		if got != want {
+			t.Errorf("sniffFormat(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/7daystosettle/data-tool/ko"
+	"github.com/7daystosettle/data-tool/project"
+)
+
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	verbose := fs.Bool("v", false, "list the properties of added and removed entities")
+	exitCode := fs.Bool("exit-code", false, "exit with status 1 when there are changes")
+	configPath := fs.String("config", "", "project file with extra identity rules (default: "+project.FileName+" in the working directory or a parent)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s diff [flags] <old> <new>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errUsage
+	}
+
+	cfg, err := loadProject(*configPath)
+	if err != nil {
+		return err
+	}
+	reg := registryFor(cfg)
+	old, err := loadFile(fs.Arg(0), reg)
+	if err != nil {
+		return err
+	}
+	new, err := loadFile(fs.Arg(1), reg)
+	if err != nil {
+		return err
+	}
+
+	changes := ko.Diff(old, new)
+	printDiff(os.Stdout, changes, *verbose)
+	if *exitCode && len(changes) > 0 {
+		return errNotEqual
+	}
+	return nil
+}
+
+// printDiff writes changes one entity per line, with +, - or ~ for added,
+// removed and changed, followed by the changed properties.
+func printDiff(w io.Writer, changes []ko.EntityChange, verbose bool) {
+	for _, c := range changes {
+		fmt.Fprintf(w, "%s %s %s\n", changeMarker(c.Kind), c.Type, c.Key)
+		if c.Kind != ko.ChangeModified && !verbose {
+			continue
+		}
+		printProperties(w, c.Properties)
+	}
+}
+
+// printProperties writes property changes indented under their entity.
+func printProperties(w io.Writer, props []ko.PropertyChange) {
+	for _, p := range props {
+		switch p.Kind {
+		case ko.ChangeAdded:
+			fmt.Fprintf(w, "    + %s: %s\n", p.Name, p.New)
+		case ko.ChangeRemoved:
+			fmt.Fprintf(w, "    - %s: %s\n", p.Name, p.Old)
+		default:
+			fmt.Fprintf(w, "    ~ %s: %s -> %s\n", p.Name, p.Old, p.New)
+		}
+	}
+}
+
+func changeMarker(kind string) string {
+	switch kind {
+	case ko.ChangeAdded:
+		return "+"
+	case ko.ChangeRemoved:
+		return "-"
+	default:
+		return "~"
+	}
+}
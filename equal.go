@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/7daystosettle/data-tool/ko"
+	"github.com/7daystosettle/data-tool/project"
+)
+
+// errNotEqual is returned by equal when the documents differ, so the command
+// exits non-zero.
+var errNotEqual = errors.New("documents differ")
+
+// Names accepted by equal --ignore.
+const (
+	ignoreComments       = "comments"
+	ignoreAttributeOrder = "attribute-order"
+	ignoreWhitespace     = "whitespace"
+	ignoreKeyedOrder     = "keyed-order"
+)
+
+func runEqual(args []string) error {
+	fs := flag.NewFlagSet("equal", flag.ContinueOnError)
+	ignore := fs.String("ignore", ignoreComments+","+ignoreAttributeOrder+","+ignoreWhitespace,
+		"comma separated differences to ignore: "+strings.Join([]string{ignoreComments, ignoreAttributeOrder, ignoreWhitespace, ignoreKeyedOrder}, ", "))
+	quiet := fs.Bool("q", false, "print nothing, only set the exit code")
+	configPath := fs.String("config", "", "project file with extra identity rules (default: "+project.FileName+" in the working directory or a parent)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s equal [flags] <a> <b>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errUsage
+	}
+
+	opts, err := parseIgnore(*ignore)
+	if err != nil {
+		return fmt.Errorf("--ignore: %w", err)
+	}
+	cfg, err := loadProject(*configPath)
+	if err != nil {
+		return err
+	}
+	reg := registryFor(cfg)
+	a, err := loadFile(fs.Arg(0), reg)
+	if err != nil {
+		return err
+	}
+	b, err := loadFile(fs.Arg(1), reg)
+	if err != nil {
+		return err
+	}
+
+	diffs := ko.Compare(a, b, opts)
+	if len(diffs) == 0 {
+		return nil
+	}
+	if !*quiet {
+		for _, d := range diffs {
+			fmt.Println(d)
+		}
+		fmt.Printf("%d differences\n", len(diffs))
+	}
+	return errNotEqual
+}
+
+func parseIgnore(s string) (ko.CompareOptions, error) {
+	var opts ko.CompareOptions
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case ignoreComments:
+			opts.IgnoreComments = true
+		case ignoreAttributeOrder:
+			opts.IgnoreAttributeOrder = true
+		case ignoreWhitespace:
+			opts.IgnoreWhitespace = true
+		case ignoreKeyedOrder:
+			opts.IgnoreKeyedOrder = true
+		default:
+			return opts, fmt.Errorf("unknown difference %q", name)
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/7daystosettle/data-tool/project"
+	"github.com/7daystosettle/data-tool/steam"
+)
+
+func runGame(args []string) error {
+	fs := flag.NewFlagSet("game", flag.ContinueOnError)
+	configPath := fs.String("config", "", "project file (default: "+project.FileName+" in the working directory or a parent)")
+	steamRoot := fs.String("steam", "", "Steam install to search (default: the project file's, else the usual locations)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s game [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+
+	cfg, err := loadProject(*configPath)
+	if err != nil {
+		return err
+	}
+	if cfg.Game != "" && *steamRoot == "" {
+		in, err := steam.InstallAt(cfg.Game)
+		switch {
+		case err == nil:
+			printInstall(in)
+		case errors.Is(err, steam.ErrNotFound):
+			fmt.Printf("%s (from %s; no Steam app manifest for it, so its build is unknown)\n", cfg.Game, project.FileName)
+		default:
+			return err
+		}
+	} else {
+		installs, err := steam.Find(steamRoots(cfg, *steamRoot)...)
+		if err != nil {
+			return fmt.Errorf("find installs: %w", err)
+		}
+		for i := range installs {
+			printInstall(&installs[i])
+		}
+	}
+
+	dir, err := vanillaConfigDir(cfg, *steamRoot)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Vanilla configs: %s\n", dir)
+	return nil
+}
+
+// printInstall prints an install with its Steam build, which is not the
+// game's version number.
+func printInstall(in *steam.Install) {
+	branch := in.Branch
+	if branch == "" {
+		branch = "public"
+	}
+	fmt.Printf("%s (app %s, Steam build %s, branch %s)\n  %s\n", in.Name, in.AppID, in.SteamBuildID, branch, in.Dir)
+}
+
+// steamRoots returns the Steam installs to search: the flag, then the
+// project file, then nil for the platform defaults.
+func steamRoots(cfg *project.Config, flagRoot string) []string {
+	if flagRoot != "" {
+		return []string{flagRoot}
+	}
+	if cfg.Steam != "" {
+		return []string{cfg.Steam}
+	}
+	return nil
+}
+
+// vanillaConfigDir returns the game's Data/Config directory, from the project
+// file's game path when set and otherwise by discovering the Steam install.
+// A --steam flag, flagRoot, wins over the game path.
+func vanillaConfigDir(cfg *project.Config, flagRoot string) (string, error) {
+	if cfg.Game != "" && flagRoot == "" {
+		return filepath.Join(cfg.Game, "Data", "Config"), nil
+	}
+	in, err := steam.Discover(steamRoots(cfg, flagRoot)...)
+	if err != nil {
+		return "", fmt.Errorf("discover game install (set game in %s or pass --steam): %w", project.FileName, err)
+	}
+	return in.ConfigDir(), nil
+}
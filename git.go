@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/7daystosettle/data-tool/ko"
+)
+
+// gitDriver is the name of the diff and merge drivers data-tool registers
+// with git.
+const gitDriver = "datatool"
+
+// gitNullFile is what git passes for the missing side of an added or
+// deleted file.
+const gitNullFile = "/dev/null"
+
+func runGit(args []string) error {
+	if len(args) == 0 {
+		printGitUsage()
+		return errUsage
+	}
+	switch args[0] {
+	case "textconv":
+		return runGitTextconv(args[1:])
+	case "diff-driver":
+		return runGitDiffDriver(args[1:])
+	case "merge-driver":
+		return runGitMergeDriver(args[1:])
+	case "install":
+		return runGitInstall(args[1:])
+	case "help", "-h", "--help":
+		printGitUsage()
+		return nil
+	default:
+		printGitUsage()
+		return errUsage
+	}
+}
+
+func printGitUsage() {
+	fmt.Fprintf(os.Stderr, `usage: %[1]s git <command> [args]
+
+commands:
+  textconv <file>
+      print a config file as canonical KDL, for git's diff.<driver>.textconv
+  diff-driver <path> <old-file> <old-hex> <old-mode> <new-file> <new-hex> <new-mode>
+      print the entity diff of a file, for git's diff.<driver>.command
+  merge-driver <base> <ours> <theirs> [path]
+      merge a file entity by entity into ours, for git's merge.<driver>.driver
+  install [--command path] [--dry-run]
+      register all three with git for *.xml and *.kdl in the current repository
+`, os.Args[0])
+}
+
+func runGitTextconv(args []string) error {
+	if len(args) != 1 {
+		printGitUsage()
+		return errUsage
+	}
+	doc, err := loadFile(args[0], nil)
+	if err != nil {
+		return err
+	}
+	return writeDoc(doc, os.Stdout, formatKdl)
+}
+
+func runGitDiffDriver(args []string) error {
+	// git passes just the path for unmerged files.
+	if len(args) == 1 {
+		fmt.Printf("* Unmerged path %s\n", args[0])
+		return nil
+	}
+	if len(args) < 7 {
+		printGitUsage()
+		return errUsage
+	}
+	cfg, err := loadProject("")
+	if err != nil {
+		return err
+	}
+	return gitDiff(os.Stdout, args[0], args[1], args[4], registryFor(cfg))
+}
+
+// gitDiff writes the entity diff between the old and new versions of the
+// file git knows as path.
+func gitDiff(w io.Writer, path, oldFile, newFile string, reg *ko.Registry) error {
+	old, err := gitDoc(path, oldFile, reg)
+	if err != nil {
+		return err
+	}
+	new, err := gitDoc(path, newFile, reg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "diff --%s a/%s b/%s\n", gitDriver, path, path)
+	printDiff(w, ko.Diff(old, new), false)
+	return nil
+}
+
+// gitDoc loads one side of a git diff. git names its temporary copies after
+// the file, so the format comes from path.
+func gitDoc(path, file string, reg *ko.Registry) (*ko.Ko, error) {
+	if file == gitNullFile {
+		return ko.NewFromKdl(strings.NewReader(""))
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	format := formatFromPath(path)
+	if format == "" {
+		format = formatXml
+	}
+	doc, err := readDoc(bytes.NewReader(b), format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	doc.SetSource(path)
+	doc.SetRegistry(reg)
+	return doc, nil
+}
+
+func runGitMergeDriver(args []string) error {
+	if len(args) != 3 && len(args) != 4 {
+		printGitUsage()
+		return errUsage
+	}
+	// %P is the file's path in the repository; older configs may omit it,
+	// leaving only git's temporary names.
+	path := args[1]
+	if len(args) == 4 {
+		path = args[3]
+	}
+	cfg, err := loadProject("")
+	if err != nil {
+		return err
+	}
+	conflicts, err := gitMerge(path, args[0], args[1], args[2], registryFor(cfg))
+	if err != nil {
+		return err
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	for _, c := range conflicts {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, c)
+	}
+	return errConflicts
+}
+
+// gitMerge merges base, ours and theirs, the versions of the file git knows
+// as path, and writes the result over ours as git expects of a merge driver.
+func gitMerge(path, base, ours, theirs string, reg *ko.Registry) ([]ko.Conflict, error) {
+	var docs [3]*ko.Ko
+	for i, file := range []string{base, ours, theirs} {
+		doc, err := gitDoc(path, file, reg)
+		if err != nil {
+			return nil, err
+		}
+		docs[i] = doc
+	}
+	merged, conflicts := ko.Merge(docs[0], docs[1], docs[2])
+	format := formatFromPath(path)
+	if format == "" {
+		format = formatXml
+	}
+	err := writeFileAtomic(ours, false, func(w io.Writer) error {
+		return writeDoc(merged, w, format)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+func runGitInstall(args []string) error {
+	fs := flag.NewFlagSet("git install", flag.ContinueOnError)
+	command := fs.String("command", "", "how git should run data-tool (default: data-tool when on PATH, else this executable)")
+	dryRun := fs.Bool("dry-run", false, "print the changes instead of making them")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s git install [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	exe, err := gitCommand(*command)
+	if err != nil {
+		return err
+	}
+	top, err := gitOutput("rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+
+	settings := gitSettings(exe)
+	attrsPath := filepath.Join(top, ".gitattributes")
+	if *dryRun {
+		for _, s := range settings {
+			fmt.Printf("git config %s %q\n", s[0], s[1])
+		}
+		for _, line := range gitAttributes() {
+			fmt.Printf("%s: %s\n", attrsPath, line)
+		}
+		return nil
+	}
+
+	for _, s := range settings {
+		_, err := gitOutput("config", s[0], s[1])
+		if err != nil {
+			return err
+		}
+	}
+	old, err := os.ReadFile(attrsPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read .gitattributes: %w", err)
+	}
+	updated := addLines(string(old), gitAttributes())
+	if updated != string(old) {
+		err = writeFileAtomic(attrsPath, false, func(w io.Writer) error {
+			_, err := io.WriteString(w, updated)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("registered the %s diff and merge drivers; commit %s to share them\n", gitDriver, attrsPath)
+	return nil
+}
+
+// gitCommand returns how git should invoke data-tool.
+func gitCommand(flagCommand string) (string, error) {
+	if flagCommand != "" {
+		return flagCommand, nil
+	}
+	if _, err := exec.LookPath("data-tool"); err == nil {
+		return "data-tool", nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("find executable (pass --command): %w", err)
+	}
+	return exe, nil
+}
+
+// gitSettings returns the git config entries that register the driver,
+// running data-tool as exe.
+func gitSettings(exe string) [][2]string {
+	exe = gitQuote(exe)
+	return [][2]string{
+		{"diff." + gitDriver + ".textconv", exe + " git textconv"},
+		{"diff." + gitDriver + ".command", exe + " git diff-driver"},
+		{"merge." + gitDriver + ".name", "data-tool entity-level merge"},
+		{"merge." + gitDriver + ".driver", exe + " git merge-driver %O %A %B %P"},
+	}
+}
+
+// gitAttributes returns the .gitattributes lines that select the drivers.
+func gitAttributes() []string {
+	return []string{
+		"*.xml diff=" + gitDriver + " merge=" + gitDriver,
+		"*.kdl diff=" + gitDriver + " merge=" + gitDriver,
+	}
+}
+
+// gitQuote quotes path for a git config command line when it needs it.
+func gitQuote(path string) string {
+	if !strings.ContainsAny(path, " \t'\"\\") {
+		return path
+	}
+	return "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+}
+
+// addLines adds each of lines to text, the content of a .gitattributes
+// file. A line for the same pattern that already selects one of our drivers,
+// as written by an earlier install, is replaced; otherwise the line is
+// appended.
+func addLines(text string, lines []string) string {
+	existing := strings.Split(text, "\n")
+	for _, l := range lines {
+		pattern := strings.Fields(l)[0]
+		found := false
+		for i, e := range existing {
+			fields := strings.Fields(e)
+			if len(fields) > 0 && fields[0] == pattern && strings.Contains(e, "="+gitDriver) {
+				existing[i], found = l, true
+				break
+			}
+		}
+		if !found {
+			if n := len(existing); n > 0 && existing[n-1] == "" {
+				existing = existing[:n-1]
+			}
+			existing = append(existing, l, "")
+		}
+	}
+	return strings.Join(existing, "\n")
+}
+
+// gitOutput runs git with args and returns its trimmed output.
+func gitOutput(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
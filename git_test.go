@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitDiff(t *testing.T) {
+	dir := t.TempDir()
+	// git names its temporary copies freely; the format comes from the path.
+	tmp := filepath.Join(dir, "blob")
+	if err := os.WriteFile(tmp, []byte(`<items><item name="gunPistol"/></items>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := gitDiff(&b, "Config/items.xml", gitNullFile, tmp, nil); err != nil {
+		t.Fatalf("gitDiff: %v", err)
+	}
+	want := "diff --datatool a/Config/items.xml b/Config/items.xml\n+ item gunPistol\n"
+	if b.String() != want {
+		t.Errorf("gitDiff = %q, want %q", b.String(), want)
+	}
+}
+
+func TestAddLines(t *testing.T) {
+	lines := gitAttributes()
+	got := addLines("*.png binary", lines)
+	want := "*.png binary\n*.xml diff=datatool merge=datatool\n*.kdl diff=datatool merge=datatool\n"
+	if got != want {
+		t.Errorf("addLines = %q, want %q", got, want)
+	}
+	if again := addLines(got, lines); again != got {
+		t.Errorf("addLines is not idempotent: %q", again)
+	}
+	if upgraded := addLines("*.xml diff=datatool\n*.kdl diff=datatool\n*.png binary\n", lines); upgraded != "*.xml diff=datatool merge=datatool\n*.kdl diff=datatool merge=datatool\n*.png binary\n" {
+		t.Errorf("addLines did not replace the lines of an earlier install: %q", upgraded)
+	}
+}
+
+func TestGitMerge(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"base":   `<buffs><buff name="a" duration="1"/><buff name="b" duration="1"/></buffs>`,
+		"ours":   `<buffs><buff name="a" duration="2"/><buff name="b" duration="1"/></buffs>`,
+		"theirs": `<buffs><buff name="a" duration="1"/><buff name="b" duration="3"/></buffs>`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ours := filepath.Join(dir, "ours")
+	conflicts, err := gitMerge("Config/buffs.xml", filepath.Join(dir, "base"), ours, filepath.Join(dir, "theirs"), nil)
+	if err != nil {
+		t.Fatalf("gitMerge: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("conflicts: %v", conflicts)
+	}
+	got, err := os.ReadFile(ours)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<buff name="a" duration="2"/>`, `<buff name="b" duration="3"/>`} {
+		if !bytes.Contains(got, []byte(want)) {
+			t.Errorf("merged file lacks %s:\n%s", want, got)
+		}
+	}
+}
+
+// Comments survive the merge, so vanilla files, which have many, still
+// merge by entity.
+func TestGitMergeComments(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"base":   "<buffs>\n<buff name=\"a\" duration=\"1\"/>\n<!-- keep me -->\n<buff name=\"b\" duration=\"1\"/>\n</buffs>\n",
+		"ours":   "<buffs>\n<buff name=\"a\" duration=\"2\"/>\n<!-- keep me -->\n<buff name=\"b\" duration=\"1\"/>\n</buffs>\n",
+		"theirs": "<buffs>\n<buff name=\"a\" duration=\"1\"/>\n<!-- keep me -->\n<buff name=\"b\" duration=\"3\"/>\n</buffs>\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ours := filepath.Join(dir, "ours")
+	conflicts, err := gitMerge("Config/buffs.xml", filepath.Join(dir, "base"), ours, filepath.Join(dir, "theirs"), nil)
+	if err != nil {
+		t.Fatalf("gitMerge: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("conflicts: %v", conflicts)
+	}
+	got, err := os.ReadFile(ours)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<buff name="a" duration="2"/>`, `<!-- keep me -->`, `<buff name="b" duration="3"/>`} {
+		if !bytes.Contains(got, []byte(want)) {
+			t.Errorf("merged file lacks %s:\n%s", want, got)
+		}
+	}
+}
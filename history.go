@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/7daystosettle/data-tool/ko"
+	"github.com/7daystosettle/data-tool/snapshot"
+)
+
+func runHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	sf := addSnapshotFlags(fs)
+	property := fs.String("property", "", "only report changes to this property, such as EntityDamage")
+	ok, err := parseSnapshotFlags(fs, args, "history [flags] <file> <entity>", 2, 2)
+	if !ok {
+		return err
+	}
+
+	cfg, store, err := sf.open()
+	if err != nil {
+		return err
+	}
+	snaps, err := store.List()
+	if err != nil {
+		return err
+	}
+	if len(snaps) == 0 {
+		return fmt.Errorf("no snapshots in %s; add some with snapshot import", store.Dir)
+	}
+
+	reg := registryFor(cfg)
+	file := fs.Arg(0)
+	versions := make([]historyVersion, 0, len(snaps))
+	found := 0
+	for _, snap := range snaps {
+		doc, err := snapshotFile(store, snap, file, reg)
+		if err != nil {
+			return err
+		}
+		if doc != nil {
+			found++
+		}
+		versions = append(versions, historyVersion{label: snap.Label, doc: doc})
+	}
+	if found == 0 {
+		return fmt.Errorf("no snapshot has %s", file)
+	}
+
+	entries := entityHistory(versions, fs.Arg(1), *property)
+	if len(entries) == 0 {
+		what := fs.Arg(1)
+		if *property != "" {
+			what = *property + " of " + what
+		}
+		fmt.Fprintf(os.Stderr, "%s: no changes to %s in %d snapshots\n", file, what, found)
+		return nil
+	}
+	printHistory(os.Stdout, entries)
+	return nil
+}
+
+// snapshotFile loads the config file named file, with or without its
+// extension, from snap. A snapshot without the file gives a nil document.
+func snapshotFile(store *snapshot.Store, snap *snapshot.Snapshot, file string, reg *ko.Registry) (*ko.Ko, error) {
+	want := strings.TrimSuffix(file, path.Ext(file))
+	for _, name := range snap.Names() {
+		if !strings.EqualFold(strings.TrimSuffix(name, path.Ext(name)), want) {
+			continue
+		}
+		b, err := store.Read(snap.Files[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", snap.Label, name, err)
+		}
+		doc, err := readDoc(bytes.NewReader(b), formatKdl)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", snap.Label, name, err)
+		}
+		doc.SetSource(name)
+		doc.SetRegistry(reg)
+		return doc, nil
+	}
+	return nil, nil
+}
+
+// historyVersion is one version of a config file; doc is nil when the
+// snapshot does not have the file.
+type historyVersion struct {
+	label string
+	doc   *ko.Ko
+}
+
+// historyEntry is a version in which an entity changed.
+type historyEntry struct {
+	label  string
+	change ko.EntityChange
+}
+
+// entityHistory diffs each version against the one before it and returns the
+// changes to the entities keyed key, such as gunPistol, or whose key starts
+// with it, such as bread (craft_area=campfire). With property set, only
+// versions that changed that property are kept, and only its change.
+// Versions without the file are skipped, so a gap does not show its entities
+// as removed and added again.
+func entityHistory(versions []historyVersion, key, property string) []historyEntry {
+	var entries []historyEntry
+	prev, _ := ko.NewFromKdl(strings.NewReader(""))
+	for _, v := range versions {
+		if v.doc == nil {
+			continue
+		}
+		for _, c := range ko.Diff(prev, v.doc) {
+			if c.Key != key && !strings.HasPrefix(c.Key, key+" (") {
+				continue
+			}
+			if property != "" {
+				var props []ko.PropertyChange
+				for _, p := range c.Properties {
+					if p.Name == property {
+						props = append(props, p)
+					}
+				}
+				if len(props) == 0 {
+					continue
+				}
+				c.Properties = props
+			}
+			entries = append(entries, historyEntry{label: v.label, change: c})
+		}
+		prev = v.doc
+	}
+	return entries
+}
+
+func printHistory(w io.Writer, entries []historyEntry) {
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s: %s %s %s\n", e.label, e.change.Kind, e.change.Type, e.change.Key)
+		printProperties(w, e.change.Properties)
+	}
+}
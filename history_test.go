@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/7daystosettle/data-tool/ko"
+)
+
+func TestEntityHistory(t *testing.T) {
+	var versions []historyVersion
+	for i, src := range []string{
+		`items { item name="gunPistol" { property name="EntityDamage" value="30"; property name="Weight" value="1"; }; }`,
+		`items { item name="gunPistol" { property name="EntityDamage" value="30"; property name="Weight" value="2"; }; }`,
+		`items { item name="gunPistol" { property name="EntityDamage" value="32"; property name="Weight" value="2"; }; }`,
+		`items { item name="gunRifle"; }`,
+	} {
+		doc, err := ko.NewFromKdl(strings.NewReader(src))
+		if err != nil {
+			t.Fatalf("version %d: %v", i, err)
+		}
+		versions = append(versions, historyVersion{label: "v" + string(rune('1'+i)), doc: doc})
+	}
+
+	var b bytes.Buffer
+	printHistory(&b, entityHistory(versions, "gunPistol", "EntityDamage"))
+	want := `v1: added item gunPistol
+    + EntityDamage: 30
+v3: changed item gunPistol
+    ~ EntityDamage: 30 -> 32
+v4: removed item gunPistol
+    - EntityDamage: 32
+`
+	if b.String() != want {
+		t.Errorf("history of EntityDamage:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	if got := entityHistory(versions, "gunPistol", ""); len(got) != 4 {
+		t.Errorf("history of gunPistol has %d entries, want 4", len(got))
+	}
+	if got := entityHistory(versions, "gunShotgun", ""); len(got) != 0 {
+		t.Errorf("history of a missing entity = %+v", got)
+	}
+
+	// A snapshot without the file is a gap, not a removal and re-adding.
+	gap := append([]historyVersion{}, versions[:1]...)
+	gap = append(gap, historyVersion{label: "v1.5"})
+	gap = append(gap, versions[1:]...)
+	b.Reset()
+	printHistory(&b, entityHistory(gap, "gunPistol", "EntityDamage"))
+	if b.String() != want {
+		t.Errorf("history across a gap:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
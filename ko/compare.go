@@ -0,0 +1,348 @@
+package ko
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sblinch/kdl-go/document"
+)
+
+// CompareOptions selects differences that Compare does not report.
+type CompareOptions struct {
+	// IgnoreComments skips comments entirely.
+	IgnoreComments bool
+	// IgnoreAttributeOrder skips the order of an element's attributes.
+	IgnoreAttributeOrder bool
+	// IgnoreWhitespace drops whitespace-only text and compares the rest
+	// with runs of whitespace collapsed and trimmed.
+	IgnoreWhitespace bool
+	// IgnoreKeyedOrder skips the order of sibling elements that are
+	// identified by a key, such as items by name.
+	IgnoreKeyedOrder bool
+}
+
+// Kinds of Difference.
+const (
+	DiffElement   = "element"
+	DiffAttribute = "attribute"
+	DiffText      = "text"
+	DiffComment   = "comment"
+	DiffOrder     = "order"
+)
+
+// Difference is one way in which two documents differ. Path locates it in the
+// style of an XPath, using keys where elements have one, for example
+// /items/item[@name='gunPistol']/@value. Old or New is empty when the thing
+// only exists on one side.
+type Difference struct {
+	Kind string
+	Path string
+	Old  string
+	New  string
+}
+
+func (d Difference) String() string {
+	switch {
+	case d.Old == "" && d.New != "":
+		return fmt.Sprintf("%s: %s added: %s", d.Path, d.Kind, d.New)
+	case d.New == "" && d.Old != "":
+		return fmt.Sprintf("%s: %s removed: %s", d.Path, d.Kind, d.Old)
+	default:
+		return fmt.Sprintf("%s: %s changed: %s -> %s", d.Path, d.Kind, d.Old, d.New)
+	}
+}
+
+// Equal reports whether a and b hold the same data under opts.
+func Equal(a, b *Ko, opts CompareOptions) bool {
+	return len(Compare(a, b, opts)) == 0
+}
+
+// Compare returns every difference between a and b that opts does not
+// ignore, in document order of a.
+func Compare(a, b *Ko, opts CompareOptions) []Difference {
+	c := &comparer{a: a, b: b, opts: opts}
+	c.children("", nil, a.doc.Nodes, b.doc.Nodes)
+	return c.diffs
+}
+
+type comparer struct {
+	a, b  *Ko
+	opts  CompareOptions
+	diffs []Difference
+}
+
+func (c *comparer) add(kind, path, old, new string) {
+	c.diffs = append(c.diffs, Difference{Kind: kind, Path: path, Old: old, New: new})
+}
+
+// children compares the content of two nodes, or of two documents, at path.
+// parents holds the element names from the root down to the two nodes.
+func (c *comparer) children(path string, parents []string, an, bn []*document.Node) {
+	at, bt := c.text(nil, an), c.text(nil, bn)
+	if at != bt {
+		c.add(DiffText, path+"/text()", at, bt)
+	}
+
+	if !c.opts.IgnoreComments {
+		ac, bc := c.comments(an), c.comments(bn)
+		for i := 0; i < len(ac) || i < len(bc); i++ {
+			p := fmt.Sprintf("%s/comment()[%d]", path, i+1)
+			switch {
+			case i >= len(bc):
+				c.add(DiffComment, p, ac[i], "")
+			case i >= len(ac):
+				c.add(DiffComment, p, "", bc[i])
+			case ac[i] != bc[i]:
+				c.add(DiffComment, p, ac[i], bc[i])
+			}
+		}
+	}
+
+	aIDs, aElems := identify(c.a, c.b, parents, an, bn)
+	bIDs, bElems := identify(c.b, c.a, parents, bn, an)
+
+	var aCommon, bCommon []string
+	for _, id := range aIDs {
+		if _, ok := bElems[id]; ok {
+			aCommon = append(aCommon, id)
+		} else {
+			c.add(DiffElement, path+"/"+id, describe(aElems[id]), "")
+		}
+	}
+	for _, id := range bIDs {
+		if _, ok := aElems[id]; ok {
+			bCommon = append(bCommon, id)
+		} else {
+			c.add(DiffElement, path+"/"+id, "", describe(bElems[id]))
+		}
+	}
+	aOrder, bOrder := aCommon, bCommon
+	if c.opts.IgnoreKeyedOrder {
+		// Unkeyed siblings are only told apart by position, so their order
+		// is still compared.
+		aOrder, bOrder = unkeyedIDs(c.a, parents, aCommon, aElems), unkeyedIDs(c.b, parents, bCommon, bElems)
+	}
+	if strings.Join(aOrder, "\x00") != strings.Join(bOrder, "\x00") {
+		c.add(DiffOrder, path+"/*", strings.Join(aOrder, ", "), strings.Join(bOrder, ", "))
+	}
+
+	for _, id := range aCommon {
+		c.element(path+"/"+id, parents, aElems[id], bElems[id])
+	}
+}
+
+func (c *comparer) element(path string, parents []string, a, b *document.Node) {
+	for _, k := range unionKeys(a.Properties, b.Properties) {
+		av, aok := a.Properties[k]
+		bv, bok := b.Properties[k]
+		switch {
+		case !bok:
+			c.add(DiffAttribute, path+"/@"+k, av.ValueString(), "")
+		case !aok:
+			c.add(DiffAttribute, path+"/@"+k, "", bv.ValueString())
+		case av.ValueString() != bv.ValueString():
+			c.add(DiffAttribute, path+"/@"+k, av.ValueString(), bv.ValueString())
+		}
+	}
+
+	if !c.opts.IgnoreAttributeOrder {
+		ao := commonOrder(c.a.attributeOrder(a), b.Properties)
+		bo := commonOrder(c.b.attributeOrder(b), a.Properties)
+		if strings.Join(ao, ",") != strings.Join(bo, ",") {
+			c.add(DiffOrder, path+"/@*", strings.Join(ao, " "), strings.Join(bo, " "))
+		}
+	}
+
+	at, bt := c.text(a.Arguments, a.Children), c.text(b.Arguments, b.Children)
+	if at != bt {
+		c.add(DiffText, path+"/text()", at, bt)
+	}
+	c.children(path, appendPath(parents, a), stripText(a.Children), stripText(b.Children))
+}
+
+// text returns the text content held by args and the text nodes among
+// children, normalised as opts asks.
+func (c *comparer) text(args []*document.Value, children []*document.Node) string {
+	var parts []string
+	for _, a := range args {
+		parts = append(parts, a.ValueString())
+	}
+	for _, n := range children {
+		if n.Name.NodeNameString() == textNodeIdentifier && len(n.Arguments) > 0 {
+			parts = append(parts, n.Arguments[0].ValueString())
+		}
+	}
+	s := strings.Join(parts, "")
+	if c.opts.IgnoreWhitespace {
+		s = strings.Join(strings.Fields(s), " ")
+	}
+	return s
+}
+
+func (c *comparer) comments(nodes []*document.Node) []string {
+	var out []string
+	for _, n := range nodes {
+		if n.Name.NodeNameString() != commentNodeIdentifier || len(n.Arguments) == 0 {
+			continue
+		}
+		s := n.Arguments[0].ValueString()
+		if c.opts.IgnoreWhitespace {
+			s = strings.Join(strings.Fields(s), " ")
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// stripText drops the text nodes from children; text is compared on its own
+// so that inline KDL arguments and XML text compare equal.
+func stripText(children []*document.Node) []*document.Node {
+	out := make([]*document.Node, 0, len(children))
+	for _, n := range children {
+		if n.Name.NodeNameString() != textNodeIdentifier {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// attributeOrder returns the attributes of n in source order when it was
+// read from XML, and otherwise in the order they would be written.
+func (e *Ko) attributeOrder(n *document.Node) []string {
+	if order, ok := e.attrOrder[n]; ok {
+		return order
+	}
+	return propertyKeys(n.Properties, e.opts.withDefaults().AttributeOrder)
+}
+
+// unkeyedIDs returns those of ids whose elements, read by k, have no key.
+func unkeyedIDs(k *Ko, parents []string, ids []string, elems map[string]*document.Node) []string {
+	var out []string
+	for _, id := range ids {
+		if _, ok := k.key(parents, elems[id]); !ok {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// identify names each element among nodes, read by k, by a path step that
+// is stable between the two versions of a parent: its key predicate where it
+// has a key, else its position among unkeyed siblings of the same name.
+// other is the matching list read by otherK, so positions are only spelled
+// out when either side needs them.
+func identify(k, otherK *Ko, parents []string, nodes, other []*document.Node) ([]string, map[string]*document.Node) {
+	counts := k.unkeyedCounts(parents, nodes)
+	for name, n := range otherK.unkeyedCounts(parents, other) {
+		if n > counts[name] {
+			counts[name] = n
+		}
+	}
+	return k.identifyWith(parents, nodes, counts)
+}
+
+// identifyWith is identify given, per element name, the most unkeyed
+// siblings of that name any version being matched has.
+func (k *Ko) identifyWith(parents []string, nodes []*document.Node, counts map[string]int) ([]string, map[string]*document.Node) {
+	var ids []string
+	elems := make(map[string]*document.Node)
+	seen := make(map[string]int)
+	for _, n := range nodes {
+		name := n.Name.NodeNameString()
+		if isSpecialNode(name) {
+			continue
+		}
+		var id string
+		if key, ok := k.key(parents, n); ok {
+			id = name + key.Predicate()
+			seen[id]++
+			if seen[id] > 1 {
+				id = fmt.Sprintf("%s[%d]", id, seen[id])
+			}
+		} else {
+			seen[name]++
+			id = name
+			if counts[name] > 1 {
+				id = fmt.Sprintf("%s[%d]", name, seen[name])
+			}
+		}
+		ids = append(ids, id)
+		elems[id] = n
+	}
+	return ids, elems
+}
+
+func (e *Ko) unkeyedCounts(parents []string, nodes []*document.Node) map[string]int {
+	counts := make(map[string]int)
+	for _, n := range nodes {
+		name := n.Name.NodeNameString()
+		if isSpecialNode(name) {
+			continue
+		}
+		if _, ok := e.key(parents, n); !ok {
+			counts[name]++
+		}
+	}
+	return counts
+}
+
+// appendPath returns parents extended by the name of n, without sharing
+// storage with parents.
+func appendPath(parents []string, n *document.Node) []string {
+	return append(append(make([]string, 0, len(parents)+1), parents...), n.Name.NodeNameString())
+}
+
+// isSpecialNode reports whether name is one of the nodes the converter uses
+// for things other than elements.
+func isSpecialNode(name string) bool {
+	return name == "_charset" || name == commentNodeIdentifier || name == textNodeIdentifier
+}
+
+// xpathLiteral quotes s as an XPath string literal.
+func xpathLiteral(s string) string {
+	if !strings.Contains(s, "'") {
+		return "'" + s + "'"
+	}
+	if !strings.Contains(s, `"`) {
+		return `"` + s + `"`
+	}
+	parts := strings.Split(s, "'")
+	return "concat('" + strings.Join(parts, `', "'", '`) + "')"
+}
+
+func unionKeys(a, b document.Properties) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// commonOrder filters order down to the keys also present in other.
+func commonOrder(order []string, other document.Properties) []string {
+	out := make([]string, 0, len(order))
+	for _, k := range order {
+		if _, ok := other[k]; ok {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// describe renders an element's start tag for messages.
+func describe(n *document.Node) string {
+	var b strings.Builder
+	b.WriteString("<" + n.Name.NodeNameString())
+	for _, k := range propertyKeys(n.Properties, defaultAttributeOrder) {
+		fmt.Fprintf(&b, " %s=%q", k, n.Properties[k].ValueString())
+	}
+	b.WriteString(">")
+	return b.String()
+}
@@ -0,0 +1,87 @@
+package ko
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	a, err := NewFromXml(strings.NewReader(`<items>
+	<!-- guns -->
+	<item name="gunPistol"><property name="Tags" value="gun"/></item>
+	<item name="gunRifle"/>
+	<note>  keep  me </note>
+</items>`))
+	if err != nil {
+		t.Fatalf("NewFromXml: %v", err)
+	}
+	b, err := NewFromKdl(strings.NewReader(`items {
+	item name="gunRifle"
+	item name="gunPistol" {
+		property value="gun" name="Tags"
+	}
+	note "keep me"
+}
+`))
+	if err != nil {
+		t.Fatalf("NewFromKdl: %v", err)
+	}
+
+	loose := CompareOptions{IgnoreComments: true, IgnoreAttributeOrder: true, IgnoreWhitespace: true, IgnoreKeyedOrder: true}
+	if diffs := Compare(a, b, loose); len(diffs) != 0 {
+		t.Errorf("expected no differences when ignoring formatting, got %v", diffs)
+	}
+
+	diffs := Compare(a, b, CompareOptions{})
+	kinds := map[string]bool{}
+	for _, d := range diffs {
+		kinds[d.Kind] = true
+	}
+	for _, k := range []string{DiffComment, DiffOrder, DiffText} {
+		if !kinds[k] {
+			t.Errorf("missing %s difference in %v", k, diffs)
+		}
+	}
+
+	b2, err := NewFromKdl(strings.NewReader(`items {
+	item name="gunPistol" {
+		property name="Tags" value="gun,pistol"
+	}
+	item name="gunRifle"
+	note "keep me"
+}
+`))
+	if err != nil {
+		t.Fatalf("NewFromKdl: %v", err)
+	}
+	diffs = Compare(a, b2, loose)
+	want := Difference{Kind: DiffAttribute, Path: "/items/item[@name='gunPistol']/property[@name='Tags']/@value", Old: "gun", New: "gun,pistol"}
+	if len(diffs) != 1 || diffs[0] != want {
+		t.Errorf("Compare = %v, want [%v]", diffs, want)
+	}
+	if Equal(a, b2, loose) {
+		t.Error("Equal reported documents with different values as equal")
+	}
+}
+
+func TestCompareIgnoreKeyedOrder(t *testing.T) {
+	a, err := NewFromXml(strings.NewReader(`<items>
+	<item name="a"><effect_group><requirement/><triggered_effect trigger="t"/></effect_group></item>
+	<item name="b"/>
+</items>`))
+	if err != nil {
+		t.Fatalf("NewFromXml: %v", err)
+	}
+	b, err := NewFromXml(strings.NewReader(`<items>
+	<item name="b"/>
+	<item name="a"><effect_group><triggered_effect trigger="t"/><requirement/></effect_group></item>
+</items>`))
+	if err != nil {
+		t.Fatalf("NewFromXml: %v", err)
+	}
+	diffs := Compare(a, b, CompareOptions{IgnoreKeyedOrder: true})
+	want := Difference{Kind: DiffOrder, Path: "/items/item[@name='a']/effect_group/*", Old: "requirement, triggered_effect", New: "triggered_effect, requirement"}
+	if len(diffs) != 1 || diffs[0] != want {
+		t.Errorf("Compare = %v, want [%v]", diffs, want)
+	}
+}
@@ -0,0 +1,335 @@
+package ko
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// PatchEnv is what a patch is applied in: what its conditional blocks are
+// evaluated against, and where it came from.
+type PatchEnv struct {
+	// Mods maps the name of each loaded mod to its version, which may be
+	// empty.
+	Mods map[string]string
+	// GameVersion is the game's version, such as "1.2 b27".
+	GameVersion string
+	// Mod and Patch name the mod and file the patch belongs to; they are
+	// recorded in the Origin of everything it changes.
+	Mod   string
+	Patch string
+}
+
+// Names of the elements of a conditional patch block:
+//
+//	<conditional>
+//	  <if cond="mod_loaded('SCore')">...</if>
+//	  <elseif cond="game_version >= '1.1'">...</elseif>
+//	  <else>...</else>
+//	</conditional>
+const (
+	OpConditional = "conditional"
+	condIf        = "if"
+	condElseIf    = "elseif"
+	condElse      = "else"
+)
+
+// evalCondition evaluates the cond attribute of a conditional branch. It
+// understands mod_loaded('name'), mod_version('name'), game_version,
+// xpath('path'), which is true when the path matches the document being
+// patched, string literals, parentheses, not/!, and/&&, or/||, and the
+// comparisons == != < <= > >=. Values that hold numbers compare as
+// versions, number by number, so "1.10" is above "1.9" and "V1.2 b27" equals
+// "1.2.27".
+func evalCondition(cond string, env PatchEnv, tree *xtree) (bool, error) {
+	p := &condParser{env: env, tree: tree}
+	err := p.tokenize(cond)
+	if err != nil {
+		return false, fmt.Errorf("condition %q: %w", cond, err)
+	}
+	v, err := p.or()
+	if err == nil && p.pos < len(p.toks) {
+		err = fmt.Errorf("unexpected %q", p.toks[p.pos])
+	}
+	if err != nil {
+		return false, fmt.Errorf("condition %q: %w", cond, err)
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("condition %q is not true or false", cond)
+	}
+	return b, nil
+}
+
+type condParser struct {
+	env  PatchEnv
+	tree *xtree
+	toks []string
+	pos  int
+}
+
+// tokenize splits s into words, quoted strings (kept with their quotes) and
+// operators.
+func (p *condParser) tokenize(s string) error {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return fmt.Errorf("unterminated string")
+			}
+			p.toks = append(p.toks, s[i:i+end+2])
+			i += end + 2
+		case strings.ContainsRune("()!,", rune(c)) && !strings.HasPrefix(s[i:], "!="):
+			p.toks = append(p.toks, s[i:i+1])
+			i++
+		case strings.ContainsRune("=!<>&|", rune(c)):
+			j := i + 1
+			for j < len(s) && strings.ContainsRune("=&|", rune(s[j])) {
+				j++
+			}
+			p.toks = append(p.toks, s[i:j])
+			i = j
+		default:
+			j := i
+			for j < len(s) && (s[j] == '_' || s[j] == '.' || s[j] == '-' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			if j == i {
+				return fmt.Errorf("unexpected %q", c)
+			}
+			p.toks = append(p.toks, s[i:j])
+			i = j
+		}
+	}
+	return nil
+}
+
+func (p *condParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *condParser) or() (interface{}, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" || p.peek() == "||" {
+		p.pos++
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l, err = condLogic(l, r, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (p *condParser) and() (interface{}, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" || p.peek() == "&&" {
+		p.pos++
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l, err = condLogic(l, r, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func condLogic(l, r interface{}, and bool) (bool, error) {
+	lb, lok := l.(bool)
+	rb, rok := r.(bool)
+	if !lok || !rok {
+		return false, fmt.Errorf("and/or need true or false on both sides")
+	}
+	if and {
+		return lb && rb, nil
+	}
+	return lb || rb, nil
+}
+
+func (p *condParser) unary() (interface{}, error) {
+	if p.peek() == "not" || p.peek() == "!" {
+		p.pos++
+		v, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("not needs true or false")
+		}
+		return !b, nil
+	}
+	l, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek()
+	switch op {
+	case "==", "=", "!=", "<", "<=", ">", ">=":
+	default:
+		return l, nil
+	}
+	p.pos++
+	r, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	ls, lok := l.(string)
+	rs, rok := r.(string)
+	if !lok || !rok {
+		return nil, fmt.Errorf("%s compares values, not true or false", op)
+	}
+	c := compareVersions(ls, rs)
+	switch op {
+	case "==", "=":
+		return c == 0, nil
+	case "!=":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+func (p *condParser) primary() (interface{}, error) {
+	t := p.peek()
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end")
+	case t == "(":
+		p.pos++
+		v, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return v, nil
+	case t[0] == '\'' || t[0] == '"':
+		p.pos++
+		return t[1 : len(t)-1], nil
+	}
+	p.pos++
+
+	var args []string
+	if p.peek() == "(" {
+		p.pos++
+		for p.peek() != ")" {
+			if len(args) > 0 {
+				if p.peek() != "," {
+					return nil, fmt.Errorf("expected , or ) in %s()", t)
+				}
+				p.pos++
+			}
+			v, err := p.primary()
+			if err != nil {
+				return nil, err
+			}
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s() takes text arguments", t)
+			}
+			args = append(args, s)
+		}
+		p.pos++
+	}
+
+	arg := func() (string, error) {
+		if len(args) != 1 {
+			return "", fmt.Errorf("%s() takes one argument", t)
+		}
+		return args[0], nil
+	}
+	switch t {
+	case "true", "false":
+		return t == "true", nil
+	case "mod_loaded":
+		name, err := arg()
+		if err != nil {
+			return nil, err
+		}
+		_, ok := p.env.Mods[name]
+		return ok, nil
+	case "mod_version":
+		name, err := arg()
+		if err != nil {
+			return nil, err
+		}
+		return p.env.Mods[name], nil
+	case "game_version":
+		return p.env.GameVersion, nil
+	case "xpath":
+		path, err := arg()
+		if err != nil {
+			return nil, err
+		}
+		x, err := compileXPath(path)
+		if err != nil {
+			return nil, err
+		}
+		nodes, err := p.tree.selectNodes(x)
+		if err != nil {
+			return nil, err
+		}
+		return len(nodes) > 0, nil
+	}
+	if len(args) == 0 && t[0] >= '0' && t[0] <= '9' {
+		return t, nil // a bare version number
+	}
+	return nil, fmt.Errorf("unknown function %q", t)
+}
+
+var versionNumberRE = regexp.MustCompile(`\d+`)
+
+// compareVersions compares a and b number by number when both hold numbers,
+// and as text otherwise.
+func compareVersions(a, b string) int {
+	an, bn := versionNumberRE.FindAllString(a, -1), versionNumberRE.FindAllString(b, -1)
+	if len(an) == 0 || len(bn) == 0 {
+		return strings.Compare(a, b)
+	}
+	for i := 0; i < len(an) || i < len(bn); i++ {
+		var x, y int
+		if i < len(an) {
+			x, _ = strconv.Atoi(an[i])
+		}
+		if i < len(bn) {
+			y, _ = strconv.Atoi(bn[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
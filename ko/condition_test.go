@@ -0,0 +1,47 @@
+package ko
+
+import "testing"
+
+func TestEvalCondition(t *testing.T) {
+	env := PatchEnv{Mods: map[string]string{"SCore": "2.10.3", "Empty": ""}, GameVersion: "V1.2 b27"}
+	tree := newXTree(mustXml(t, `<items><item name="a"/></items>`).doc.Nodes)
+	for cond, want := range map[string]bool{
+		"mod_loaded('SCore')":                                    true,
+		`mod_loaded("Empty")`:                                    true,
+		"mod_loaded('Missing')":                                  false,
+		"!mod_loaded('Missing')":                                 true,
+		"not mod_loaded('SCore') or mod_loaded('Empty')":         true,
+		"mod_loaded('SCore') and mod_loaded('Missing')":          false,
+		"mod_loaded('SCore') && (mod_loaded('Missing') || true)": true,
+		"mod_version('SCore') >= '2.9'":                          true,
+		"mod_version('SCore') < 2.10.4":                          true,
+		"mod_version('Missing') == ''":                           true,
+		"game_version == '1.2.27'":                               true,
+		"game_version() > 'V1.10'":                               false,
+		"game_version != 'A21'":                                  true,
+		"xpath('/items/item[@name=\"a\"]')":                      true,
+		"xpath('//block')":                                       false,
+	} {
+		got, err := evalCondition(cond, env, tree)
+		if err != nil {
+			t.Errorf("evalCondition(%s): %v", cond, err)
+		} else if got != want {
+			t.Errorf("evalCondition(%s) = %v, want %v", cond, got, want)
+		}
+	}
+
+	for _, cond := range []string{
+		"",
+		"mod_loaded('SCore'",
+		"mod_loaded('a', 'b')",
+		"game_version",
+		"mod_loaded('SCore') == true",
+		"frobnicate()",
+		"'unterminated",
+		"true true",
+	} {
+		if _, err := evalCondition(cond, env, tree); err == nil {
+			t.Errorf("evalCondition(%s) succeeded", cond)
+		}
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	kdl "github.com/sblinch/kdl-go"
@@ -19,32 +21,137 @@ const (
 	textNodeIdentifier    = "_text"
 )
 
+// defaultAttributeOrder lists the attributes written first, in this order, so
+// that the identifying attributes of 7 Days to Die config elements lead.
+var defaultAttributeOrder = []string{"name", "trigger", "progression_name", "action", "cvar", "operation", "level", "value", "param1", "tier", "tags", "match_all_tags", "part", "active", "prefab", "parentTransform", "localPos"}
+
+// Options controls how a document is written.
+type Options struct {
+	// AttributeOrder lists attributes that are written first, in this order;
+	// the rest follow sorted by name. nil selects the default order.
+	AttributeOrder []string
+	// Indent is written once per nesting level. Empty selects two spaces.
+	Indent string
+	// Provenance writes the Origin of each element and attribute a patch
+	// changed, as an XML comment before the element or as KDL type
+	// annotations on the node and property.
+	Provenance bool
+	// origins is the provenance of the document being written, set when
+	// Provenance is.
+	origins map[*document.Node]*nodeOrigins
+}
+
+// DefaultOptions returns the options used when none have been set.
+func DefaultOptions() Options {
+	return Options{
+		AttributeOrder: append([]string(nil), defaultAttributeOrder...),
+		Indent:         "  ",
+	}
+}
+
+// writeOptions returns the options to write the document with.
+func (e *Ko) writeOptions() Options {
+	opts := e.opts.withDefaults()
+	if opts.Provenance {
+		opts.origins = e.origins
+	}
+	return opts
+}
+
+func (o Options) withDefaults() Options {
+	if o.AttributeOrder == nil {
+		o.AttributeOrder = defaultAttributeOrder
+	}
+	if o.Indent == "" {
+		o.Indent = "  "
+	}
+	return o
+}
+
 // Ko holds a parsed document for conversion.
 type Ko struct {
-	doc *document.Document
+	doc  *document.Document
+	opts Options
+	// attrOrder keeps the attribute order of elements read from XML, which
+	// the KDL model does not.
+	attrOrder map[*document.Node][]string
+	// file is the base name of the source file, see SetSource.
+	file string
+	// ids identifies elements for matching; nil means the default registry.
+	ids *Registry
+	// lines holds the line each element read from XML starts on.
+	lines map[*document.Node]int
+	// origins records the patch operations that changed each element, see
+	// ApplyPatchEnv.
+	origins map[*document.Node]*nodeOrigins
+	// removed keeps the provenance of the elements patches removed and of
+	// their changed descendants; removals keeps the elements themselves.
+	removed  []Blame
+	removals []removal
+}
+
+// SetOptions changes how the document is written by ToXml and ToKdl.
+func (e *Ko) SetOptions(opts Options) {
+	e.opts = opts
+}
+
+// ParseError reports malformed input together with where it was found.
+// Line and Column are 1-based; Column is 0 when the parser did not report one.
+// Err describes the problem without repeating the position.
+type ParseError struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.Column > 0 {
+		return fmt.Sprintf("line %d, column %d: %v", e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+var kdlPosRE = regexp.MustCompile(`(?s)\s*at line (\d+), column (\d+).*`)
+
+// kdlParseError extracts the position kdl-go embeds in its error text. kdl-go
+// counts lines and columns from zero, and follows the position with an
+// excerpt of the input, which is dropped.
+func kdlParseError(err error) error {
+	msg := err.Error()
+	m := kdlPosRE.FindStringSubmatchIndex(msg)
+	if m == nil {
+		return err
+	}
+	line, _ := strconv.Atoi(msg[m[2]:m[3]])
+	col, _ := strconv.Atoi(msg[m[4]:m[5]])
+	return &ParseError{Line: line + 1, Column: col + 1, Err: errors.New(strings.TrimSuffix(msg[:m[0]], ":"))}
 }
 
 // New parses XML from r into an internal KDL document model.
 func NewFromXml(r io.Reader) (*Ko, error) {
-	doc, err := xmlToKdl(r)
+	k, err := decodeXml(r)
 	if err != nil {
-		return nil, fmt.Errorf("xmlToKdl: %w", err)
+		return nil, fmt.Errorf("decodeXml: %w", err)
 	}
-	return &Ko{doc: doc}, nil
+	return k, nil
 }
 
 // NewFromKDL parses KDL from r into an internal document model.
 func NewFromKdl(r io.Reader) (*Ko, error) {
 	doc, err := kdl.Parse(r)
 	if err != nil {
-		return nil, fmt.Errorf("kdl.Parse: %w", err)
+		return nil, fmt.Errorf("kdl.Parse: %w", kdlParseError(err))
 	}
 	return &Ko{doc: doc}, nil
 }
 
 // ToKdl writes a deterministic KDL representation to w.
 func (e *Ko) ToKdl(w io.Writer) error {
-	err := writeKDL(e.doc, w)
+	err := writeKDL(e.doc, w, e.writeOptions())
 	if err != nil {
 		return fmt.Errorf("writeKDL: %w", err)
 	}
@@ -53,13 +160,17 @@ func (e *Ko) ToKdl(w io.Writer) error {
 
 func (e *Ko) ToXml(w io.Writer) error {
 	var buf bytes.Buffer
-	if err := kdlToXml(e.doc, &buf); err != nil {
+	if err := kdlToXmlOptions(e.doc, &buf, e.writeOptions()); err != nil {
 		return fmt.Errorf("kdlToXml: %w", err)
 	}
 	out, err := selfCloseEmptyElements(buf.Bytes())
 	if err != nil {
 		return fmt.Errorf("selfCloseEmptyElements: %w", err)
 	}
+	// encoding/xml escapes every apostrophe, but attributes are written in
+	// double quotes, so XPath predicates such as [@name='x'] can stay legible.
+	out = bytes.ReplaceAll(out, []byte("&#39;"), []byte("'"))
+	out = indentComments(out, e.writeOptions().Indent)
 	if _, err := w.Write(out); err != nil {
 		return fmt.Errorf("write out: %w", err)
 	}
@@ -67,6 +178,14 @@ func (e *Ko) ToXml(w io.Writer) error {
 }
 
 func xmlToKdl(r io.Reader) (*document.Document, error) {
+	k, err := decodeXml(r)
+	if err != nil {
+		return nil, err
+	}
+	return k.doc, nil
+}
+
+func decodeXml(r io.Reader) (*Ko, error) {
 	decoder := xml.NewDecoder(r)
 	var detectedCharset string
 	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
@@ -79,15 +198,25 @@ func xmlToKdl(r io.Reader) (*document.Document, error) {
 		}
 	}
 	doc := &document.Document{}
+	k := &Ko{doc: doc, attrOrder: make(map[*document.Node][]string), lines: make(map[*document.Node]int)}
 	var stack []*document.Node
 
 	for {
+		// Whitespace between elements is a token of its own, so this is
+		// where the next element starts.
+		startLine, _ := decoder.InputPos()
 		tok, err := decoder.Token()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("decoder.Token: %w", err)
+			line, col := decoder.InputPos()
+			var serr *xml.SyntaxError
+			if errors.As(err, &serr) {
+				// Its text repeats the line.
+				line, col, err = serr.Line, 0, errors.New(serr.Msg)
+			}
+			return nil, fmt.Errorf("decoder.Token: %w", &ParseError{Line: line, Column: col, Err: err})
 		}
 
 		var parent *document.Node
@@ -103,9 +232,13 @@ func xmlToKdl(r io.Reader) (*document.Document, error) {
 				Arguments:  []*document.Value{},
 				Children:   []*document.Node{},
 			}
+			order := make([]string, 0, len(se.Attr))
 			for _, a := range se.Attr {
 				node.Properties[a.Name.Local] = &document.Value{Value: a.Value}
+				order = append(order, a.Name.Local)
 			}
+			k.attrOrder[node] = order
+			k.lines[node] = startLine
 			if parent != nil {
 				parent.Children = append(parent.Children, node)
 			} else {
@@ -195,7 +328,7 @@ func xmlToKdl(r io.Reader) (*document.Document, error) {
 		doc.Nodes = append([]*document.Node{charsetNode}, doc.Nodes...)
 	}
 
-	return doc, nil
+	return k, nil
 }
 
 func parseXMLFragment(s string) ([]*document.Node, error) {
@@ -270,8 +403,12 @@ func parseXMLFragment(s string) ([]*document.Node, error) {
 }
 
 func kdlToXml(doc *document.Document, w io.Writer) error {
+	return kdlToXmlOptions(doc, w, DefaultOptions())
+}
+
+func kdlToXmlOptions(doc *document.Document, w io.Writer, opts Options) error {
 	enc := xml.NewEncoder(w)
-	enc.Indent("", "  ")
+	enc.Indent("", opts.Indent)
 
 	charset := "UTF-8"
 	nodes := doc.Nodes
@@ -287,7 +424,7 @@ func kdlToXml(doc *document.Document, w io.Writer) error {
 		return fmt.Errorf("write xml header: %w", err)
 	}
 
-	err = kdlNodesToXml(nodes, enc)
+	err = kdlNodesToXml(nodes, enc, opts)
 	if err != nil {
 		return fmt.Errorf("kdlNodesToXml: %w", err)
 	}
@@ -299,18 +436,18 @@ func kdlToXml(doc *document.Document, w io.Writer) error {
 	return nil
 }
 
-func kdlNodesToXml(nodes []*document.Node, enc *xml.Encoder) error {
+func kdlNodesToXml(nodes []*document.Node, enc *xml.Encoder, opts Options) error {
 	for _, node := range nodes {
 		switch node.Name.NodeNameString() {
 		case "_charset":
 			continue
 		case commentNodeIdentifier:
-			/* 			if len(node.Arguments) > 0 {
+			if len(node.Arguments) > 0 {
 				err := enc.EncodeToken(xml.Comment(node.Arguments[0].ValueString()))
 				if err != nil {
 					return fmt.Errorf("encode comment: %w", err)
 				}
-			} */
+			}
 			continue
 
 		case textNodeIdentifier:
@@ -323,27 +460,17 @@ func kdlNodesToXml(nodes []*document.Node, enc *xml.Encoder) error {
 			continue
 		}
 
-		attrs := make([]xml.Attr, 0, len(node.Properties))
-
-		orders := []string{"name", "trigger", "progression_name", "action", "cvar", "operation", "level", "value", "param1", "tier", "tags", "match_all_tags", "part", "active", "prefab", "parentTransform", "localPos"}
-		orderedKeys := make(map[string]struct{})
-		for _, key := range orders {
-			if v, ok := node.Properties[key]; ok {
-				attrs = append(attrs, xml.Attr{Name: xml.Name{Local: key}, Value: v.ValueString()})
-				orderedKeys[key] = struct{}{}
+		if note := opts.origins[node].comment(); note != "" {
+			err := enc.EncodeToken(xml.Comment(note))
+			if err != nil {
+				return fmt.Errorf("encode provenance: %w", err)
 			}
 		}
 
-		var rest []string
-		for k := range node.Properties {
-			if _, isOrdered := orderedKeys[k]; isOrdered {
-				continue
-			}
-			rest = append(rest, k)
-		}
-		sort.Strings(rest)
-		for _, k := range rest {
-			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: k}, Value: node.Properties[k].ValueString()})
+		attrs := make([]xml.Attr, 0, len(node.Properties))
+
+		for _, key := range propertyKeys(node.Properties, opts.AttributeOrder) {
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: key}, Value: node.Properties[key].ValueString()})
 		}
 
 		start := xml.StartElement{Name: xml.Name{Local: node.Name.NodeNameString()}, Attr: attrs}
@@ -359,7 +486,7 @@ func kdlNodesToXml(nodes []*document.Node, enc *xml.Encoder) error {
 			}
 		}
 
-		err = kdlNodesToXml(node.Children, enc)
+		err = kdlNodesToXml(node.Children, enc, opts)
 		if err != nil {
 			return fmt.Errorf("encode children for %q: %w", node.Name.NodeNameString(), err)
 		}
@@ -372,10 +499,10 @@ func kdlNodesToXml(nodes []*document.Node, enc *xml.Encoder) error {
 	return nil
 }
 
-func writeKDL(doc *document.Document, w io.Writer) error {
+func writeKDL(doc *document.Document, w io.Writer, opts Options) error {
 	bw := bufio.NewWriter(w)
 	for i, n := range doc.Nodes {
-		err := emitNode(bw, n, 0)
+		err := emitNode(bw, n, 0, opts)
 		if err != nil {
 			return fmt.Errorf("emitNode: %w", err)
 		}
@@ -393,7 +520,7 @@ func writeKDL(doc *document.Document, w io.Writer) error {
 	return nil
 }
 
-func emitNode(w *bufio.Writer, n *document.Node, depth int) error {
+func emitNode(w *bufio.Writer, n *document.Node, depth int, opts Options) error {
 	var err error
 	name := n.Name.NodeNameString()
 
@@ -401,7 +528,7 @@ func emitNode(w *bufio.Writer, n *document.Node, depth int) error {
 		if len(n.Arguments) == 0 {
 			return nil
 		}
-		err := writeCommentLines(w, depth, n.Arguments[0].ValueString())
+		err := writeCommentLines(w, depth, opts.Indent, n.Arguments[0].ValueString())
 		if err != nil {
 			return fmt.Errorf("writeCommentLines: %w", err)
 		}
@@ -415,7 +542,7 @@ func emitNode(w *bufio.Writer, n *document.Node, depth int) error {
 		len(n.Children[0].Arguments) > 0
 
 	if name == textNodeIdentifier && !isInlineText {
-		indent(w, depth)
+		indent(w, depth, opts.Indent)
 
 		err = writeKDLString(w, name)
 		if err != nil {
@@ -440,7 +567,15 @@ func emitNode(w *bufio.Writer, n *document.Node, depth int) error {
 		return nil
 	}
 
-	indent(w, depth)
+	indent(w, depth, opts.Indent)
+
+	origins := opts.origins[n]
+	if note := origins.annotation(n); note != "" {
+		err := writeKDLAnnotation(w, note)
+		if err != nil {
+			return fmt.Errorf("write node provenance: %w", err)
+		}
+	}
 
 	if strings.HasPrefix(name, "_") {
 		err := writeKDLString(w, name)
@@ -465,29 +600,19 @@ func emitNode(w *bufio.Writer, n *document.Node, depth int) error {
 		}
 	}
 
-	var keys []string
-	prior := []string{"name", "trigger", "progression_name", "action", "cvar", "operation", "level", "value", "param1", "tier", "tags", "match_all_tags", "part", "active", "prefab", "parentTransform", "localPos"}
-	inPrior := map[string]bool{}
-	for _, k := range prior {
-		if _, ok := n.Properties[k]; ok {
-			keys = append(keys, k)
-			inPrior[k] = true
-		}
-	}
-	var rest []string
-	for k := range n.Properties {
-		if !inPrior[k] {
-			rest = append(rest, k)
-		}
-	}
-	sort.Strings(rest)
-	keys = append(keys, rest...)
+	keys := propertyKeys(n.Properties, opts.AttributeOrder)
 
 	for _, k := range keys {
 		_, err = w.WriteString(" " + k + "=")
 		if err != nil {
 			return fmt.Errorf("write prop key: %w", err)
 		}
+		if note := origins.attrAnnotation(k); note != "" {
+			err = writeKDLAnnotation(w, note)
+			if err != nil {
+				return fmt.Errorf("write prop provenance: %w", err)
+			}
+		}
 		err = writeKDLString(w, n.Properties[k].ValueString())
 		if err != nil {
 			return fmt.Errorf("write prop value: %w", err)
@@ -522,13 +647,13 @@ func emitNode(w *bufio.Writer, n *document.Node, depth int) error {
 		if isInlineText && c.Name.NodeNameString() == textNodeIdentifier {
 			continue
 		}
-		err = emitNode(w, c, depth+1)
+		err = emitNode(w, c, depth+1, opts)
 		if err != nil {
 			return fmt.Errorf("emit child: %w", err)
 		}
 	}
 
-	indent(w, depth)
+	indent(w, depth, opts.Indent)
 
 	_, err = w.WriteString("}\n")
 	if err != nil {
@@ -537,13 +662,41 @@ func emitNode(w *bufio.Writer, n *document.Node, depth int) error {
 	return nil
 }
 
-func indent(w *bufio.Writer, depth int) {
+// propertyKeys returns the keys of props in write order: those listed in
+// prior first, in that order, then the rest sorted by name.
+func propertyKeys(props document.Properties, prior []string) []string {
+	keys := make([]string, 0, len(props))
+	inPrior := make(map[string]bool, len(prior))
+	for _, k := range prior {
+		if _, ok := props[k]; ok && !inPrior[k] {
+			keys = append(keys, k)
+			inPrior[k] = true
+		}
+	}
+	var rest []string
+	for k := range props {
+		if !inPrior[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+func indent(w *bufio.Writer, depth int, unit string) {
 	for i := 0; i < depth; i++ {
-		_ = w.WriteByte(' ')
-		_ = w.WriteByte(' ')
+		_, _ = w.WriteString(unit)
 	}
 }
 
+func writeKDLAnnotation(w *bufio.Writer, s string) error {
+	_, err := w.WriteString(`("` + escapeKDL(s) + `")`)
+	if err != nil {
+		return fmt.Errorf("write type annotation: %w", err)
+	}
+	return nil
+}
+
 func writeKDLString(w *bufio.Writer, s string) error {
 	_, err := w.WriteString(`"` + escapeKDL(s) + `"`)
 	if err != nil {
@@ -577,12 +730,12 @@ func escapeKDL(s string) string {
 	return b.String()
 }
 
-func writeCommentLines(w *bufio.Writer, depth int, s string) error {
+func writeCommentLines(w *bufio.Writer, depth int, unit string, s string) error {
 	// Normalize newlines
 	txt := strings.ReplaceAll(s, "\r\n", "\n")
 	lines := strings.Split(txt, "\n")
 	for i, ln := range lines {
-		indent(w, depth)
+		indent(w, depth, unit)
 		// Preserve empty lines as bare comment markers
 		content := strings.TrimRight(ln, " \t")
 		var toWrite string
@@ -602,6 +755,59 @@ func writeCommentLines(w *bufio.Writer, depth int, s string) error {
 	return nil
 }
 
+var (
+	commentRunRE = regexp.MustCompile(`(?s)((?:<!--.*?-->)+)(\n[ \t]*)?(</)?`)
+	commentRE    = regexp.MustCompile(`(?s)<!--.*?-->`)
+)
+
+// indentComments moves each comment, which encoding/xml writes straight
+// after the preceding token, onto a line of its own indented like the
+// element that follows it, or one unit deeper than the end tag that follows
+// it.
+func indentComments(in []byte, unit string) []byte {
+	var out []byte
+	last := 0
+	for _, m := range commentRunRE.FindAllSubmatchIndex(in, -1) {
+		after := m[3]
+		if m[4] >= 0 {
+			after = m[5]
+		}
+		if m[0] > 0 && in[m[0]-1] != '>' && in[m[0]-1] != '\n' || after < len(in) && in[after] != '<' {
+			continue // inside text, whose spacing is data
+		}
+		out = append(out, in[last:m[0]]...)
+		lineStart := bytes.LastIndexByte(out, '\n') + 1
+		line := out[lineStart:]
+		lineIndent := string(line[:len(line)-len(bytes.TrimLeft(line, " \t"))])
+		if len(bytes.TrimSpace(line)) == 0 {
+			out = out[:lineStart]
+		} else {
+			out = append(out, '\n')
+		}
+
+		next := lineIndent
+		if m[4] >= 0 {
+			next = string(in[m[4]+1 : m[5]])
+		}
+		indent := next
+		endTag := m[6] >= 0
+		if endTag {
+			indent += unit
+		}
+		for _, c := range commentRE.FindAll(in[m[2]:m[3]], -1) {
+			out = append(out, indent...)
+			out = append(out, c...)
+			out = append(out, '\n')
+		}
+		out = append(out, next...)
+		if endTag {
+			out = append(out, "</"...)
+		}
+		last = m[1]
+	}
+	return append(out, in[last:]...)
+}
+
 var emptyElemRE = regexp.MustCompile(`(?s)<([A-Za-z_:][\w\.\-:]*)\b([^>]*)>\s*</[A-Za-z_:][\w\.\-:]*>`)
 
 func selfCloseEmptyElements(in []byte) ([]byte, error) {
@@ -2,6 +2,7 @@ package ko
 
 import (
 	"bytes"
+	"errors"
 	"reflect"
 	"strings"
 	"testing"
@@ -71,3 +72,80 @@ func TestKdlToXml(t *testing.T) {
 		t.Errorf("KdlToXml output missing text content")
 	}
 }
+
+func TestXmlComments(t *testing.T) {
+	in := "<items><!-- guns --><item name=\"a\"><!-- one --><!-- two --></item><p>text<!-- kept --></p><!-- end --></items>"
+	doc, err := NewFromXml(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := doc.ToXml(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<items>
+  <!-- guns -->
+  <item name="a">
+    <!-- one -->
+    <!-- two -->
+  </item>
+  <p>text<!-- kept --></p>
+  <!-- end -->
+</items>`
+	if buf.String() != want {
+		t.Errorf("ToXml:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	_, err := NewFromXml(strings.NewReader("<items>\n  <item name=\"a\">\n</items>"))
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected ParseError from xml, got %v", err)
+	}
+	if perr.Line != 3 {
+		t.Errorf("xml error line = %d, want 3", perr.Line)
+	}
+	if strings.Contains(perr.Err.Error(), "line") {
+		t.Errorf("xml error %q repeats the position", perr.Err)
+	}
+
+	_, err = NewFromKdl(strings.NewReader("items {\n  item name=\"a\" {\n}\n}}\n"))
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected ParseError from kdl, got %v", err)
+	}
+	if perr.Line != 4 {
+		t.Errorf("kdl error line = %d, want 4", perr.Line)
+	}
+	if msg := perr.Err.Error(); strings.Contains(msg, "line") || strings.Contains(msg, "\n") {
+		t.Errorf("kdl error %q repeats the position", msg)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	doc, err := NewFromXml(strings.NewReader(`<items><item value="1" name="a" extra="x"/></items>`))
+	if err != nil {
+		t.Fatalf("NewFromXml: %v", err)
+	}
+	doc.SetOptions(Options{AttributeOrder: []string{"value"}, Indent: "\t"})
+
+	var buf bytes.Buffer
+	if err := doc.ToKdl(&buf); err != nil {
+		t.Fatalf("ToKdl: %v", err)
+	}
+	if want := "items {\n\titem value=\"1\" extra=\"x\" name=\"a\"\n}\n"; buf.String() != want {
+		t.Errorf("ToKdl = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestStats(t *testing.T) {
+	doc, err := NewFromXml(strings.NewReader(sampleXml))
+	if err != nil {
+		t.Fatalf("NewFromXml: %v", err)
+	}
+	want := Stats{Elements: 3, Attributes: 2, Text: 1, Comments: 1}
+	if got := doc.Stats(); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+}
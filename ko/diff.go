@@ -0,0 +1,261 @@
+package ko
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sblinch/kdl-go/document"
+)
+
+// Kinds of EntityChange and PropertyChange.
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "changed"
+)
+
+// EntityChange describes how one entity, such as an item, block, buff or
+// loot group, differs between two versions of a config file.
+type EntityChange struct {
+	Kind string
+	// Type is the element name, such as item.
+	Type string
+	// Key identifies the entity among its siblings, such as gunPistol.
+	Key string
+	// Path locates the entity, such as /items/item[@name='gunPistol'].
+	Path string
+	// Properties lists the property changes, sorted by name. For added and
+	// removed entities it lists every property the entity has.
+	Properties []PropertyChange
+}
+
+// PropertyChange is the old and new value of one flattened property of an
+// entity. See Diff for how properties are named.
+type PropertyChange struct {
+	Kind string
+	Name string
+	Old  string
+	New  string
+}
+
+// Diff matches the entities of old and new, the children of their root
+// elements, by identity and returns those that were added, removed or
+// changed.
+//
+// An entity's nested content is flattened to named properties so that
+// changes read the way the game data is written: <property name="X"
+// value="Y"/> becomes X, its other attributes X@attr, properties inside
+// <property class="C"> become C.X, and any other element becomes a path step
+// such as effect_group[1]/triggered_effect[2]@action. The entity's own
+// attributes are named @attr.
+func Diff(old, new *Ko) []EntityChange {
+	oldIDs, oldEnts := entities(old)
+	newIDs, newEnts := entities(new)
+
+	var changes []EntityChange
+	for _, id := range oldIDs {
+		oe := oldEnts[id]
+		ne, ok := newEnts[id]
+		if !ok {
+			changes = append(changes, oe.change(ChangeRemoved, propertyChanges(oe.props, nil)))
+			continue
+		}
+		if pcs := propertyChanges(oe.props, ne.props); len(pcs) > 0 {
+			changes = append(changes, ne.change(ChangeModified, pcs))
+		}
+	}
+	for _, id := range newIDs {
+		if _, ok := oldEnts[id]; !ok {
+			ne := newEnts[id]
+			changes = append(changes, ne.change(ChangeAdded, propertyChanges(nil, ne.props)))
+		}
+	}
+	return changes
+}
+
+type entity struct {
+	typ   string
+	key   string
+	path  string
+	props map[string]string
+}
+
+func (e *entity) change(kind string, props []PropertyChange) EntityChange {
+	return EntityChange{Kind: kind, Type: e.typ, Key: e.key, Path: e.path, Properties: props}
+}
+
+// entities returns the entities of k keyed by path, and the paths in
+// document order.
+func entities(k *Ko) ([]string, map[string]*entity) {
+	var ids []string
+	ents := make(map[string]*entity)
+	roots, rootSteps := k.stepNames(nil, k.doc.Nodes)
+	for i, root := range roots {
+		rootPath := "/" + rootSteps[i]
+		if len(roots) == 1 {
+			rootPath = "/" + root.Name.NodeNameString()
+		}
+		parents := appendPath(nil, root)
+		children, steps := k.stepNames(parents, root.Children)
+		for j, n := range children {
+			e := &entity{
+				typ:   n.Name.NodeNameString(),
+				path:  rootPath + "/" + steps[j],
+				props: make(map[string]string),
+			}
+			key, ok := k.key(parents, n)
+			if ok {
+				e.key = key.String()
+			} else {
+				e.key = steps[j]
+			}
+			k.flatten("", parents, n, e.props, keyAttrs(key))
+			ids = append(ids, e.path)
+			ents[e.path] = e
+		}
+	}
+	return ids, ents
+}
+
+// stepNames returns the elements among nodes, whose ancestors are parents,
+// and a path step for each: its key predicates where the element has a key,
+// else its 1-based position among unkeyed siblings of the same name.
+func (e *Ko) stepNames(parents []string, nodes []*document.Node) ([]*document.Node, []string) {
+	var elems []*document.Node
+	var steps []string
+	seen := make(map[string]int)
+	for _, n := range nodes {
+		name := n.Name.NodeNameString()
+		if isSpecialNode(name) {
+			continue
+		}
+		var step string
+		if key, ok := e.key(parents, n); ok {
+			step = name + key.Predicate()
+			seen[step]++
+			if seen[step] > 1 {
+				step = fmt.Sprintf("%s[%d]", step, seen[step])
+			}
+		} else {
+			seen[name]++
+			step = fmt.Sprintf("%s[%d]", name, seen[name])
+		}
+		elems = append(elems, n)
+		steps = append(steps, step)
+	}
+	return elems, steps
+}
+
+// flatten adds the attributes, text and descendants of n, whose ancestors
+// are parents, to props under prefix. Attributes in skip are left out, which
+// keeps an entity's key out of its properties.
+func (e *Ko) flatten(prefix string, parents []string, n *document.Node, props map[string]string, skip map[string]bool) {
+	for k, v := range n.Properties {
+		if skip[k] {
+			continue
+		}
+		props[prefix+"@"+k] = v.ValueString()
+	}
+	var text []string
+	for _, a := range n.Arguments {
+		text = append(text, a.ValueString())
+	}
+	for _, c := range n.Children {
+		if c.Name.NodeNameString() == textNodeIdentifier && len(c.Arguments) > 0 {
+			text = append(text, c.Arguments[0].ValueString())
+		}
+	}
+	if len(text) > 0 {
+		props[prefix+"text()"] = strings.Join(text, "")
+	}
+	e.flattenChildren(prefix, appendPath(parents, n), n.Children, props)
+}
+
+// flattenChildren adds the elements among nodes, whose ancestors are parents,
+// to props under prefix.
+func (e *Ko) flattenChildren(prefix string, parents []string, nodes []*document.Node, props map[string]string) {
+	children, steps := e.stepNames(parents, nodes)
+	for i, c := range children {
+		if c.Name.NodeNameString() == "property" {
+			if name, ok := c.Properties["name"]; ok {
+				e.flattenProperty(prefix+name.ValueString(), parents, c, props)
+				continue
+			}
+			if class, ok := c.Properties["class"]; ok {
+				e.flattenClass(prefix+class.ValueString()+".", parents, c, props)
+				continue
+			}
+		}
+		// The key is already spelled out in the step.
+		key, _ := e.key(parents, c)
+		e.flatten(prefix+steps[i]+"/", parents, c, props, keyAttrs(key))
+	}
+}
+
+// flattenProperty records <property name="X" value="Y" .../> as X and X@attr.
+func (e *Ko) flattenProperty(name string, parents []string, n *document.Node, props map[string]string) {
+	for k, v := range n.Properties {
+		switch k {
+		case "name":
+		case "value":
+			props[name] = v.ValueString()
+		default:
+			props[name+"@"+k] = v.ValueString()
+		}
+	}
+	if _, ok := n.Properties["value"]; !ok {
+		if _, ok := props[name]; !ok {
+			props[name] = ""
+		}
+	}
+	e.flattenChildren(name+"/", appendPath(parents, n), n.Children, props)
+}
+
+// flattenClass records the properties inside <property class="C"> as C.X.
+func (e *Ko) flattenClass(prefix string, parents []string, n *document.Node, props map[string]string) {
+	for k, v := range n.Properties {
+		if k != "class" {
+			props[strings.TrimSuffix(prefix, ".")+"@"+k] = v.ValueString()
+		}
+	}
+	e.flattenChildren(prefix, appendPath(parents, n), n.Children, props)
+}
+
+// keyAttrs returns the attributes that make up key.
+func keyAttrs(key Key) map[string]bool {
+	attrs := make(map[string]bool, len(key))
+	for _, p := range key {
+		attrs[p.Attr] = true
+	}
+	return attrs
+}
+
+// propertyChanges compares two flattened property sets; either may be nil.
+func propertyChanges(old, new map[string]string) []PropertyChange {
+	var names []string
+	for k := range old {
+		names = append(names, k)
+	}
+	for k := range new {
+		if _, ok := old[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []PropertyChange
+	for _, name := range names {
+		ov, inOld := old[name]
+		nv, inNew := new[name]
+		switch {
+		case !inNew:
+			changes = append(changes, PropertyChange{Kind: ChangeRemoved, Name: name, Old: ov})
+		case !inOld:
+			changes = append(changes, PropertyChange{Kind: ChangeAdded, Name: name, New: nv})
+		case ov != nv:
+			changes = append(changes, PropertyChange{Kind: ChangeModified, Name: name, Old: ov, New: nv})
+		}
+	}
+	return changes
+}
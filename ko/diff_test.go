@@ -0,0 +1,53 @@
+package ko
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old, err := NewFromXml(strings.NewReader(`<items>
+	<item name="gunPistol">
+		<property name="Tags" value="gun"/>
+		<property class="Action0"><property name="Delay" value=".2"/></property>
+		<effect_group><passive_effect name="EntityDamage" operation="base_set" value="32"/></effect_group>
+	</item>
+	<item name="oldGun"/>
+</items>`))
+	if err != nil {
+		t.Fatalf("NewFromXml: %v", err)
+	}
+	new, err := NewFromKdl(strings.NewReader(`items {
+	item name="newGun" {
+		property name="Tags" value="x"
+	}
+	item name="gunPistol" {
+		property name="Tags" value="gun,pistol"
+		property class="Action0" {
+			property name="Delay" value=".2"
+		}
+		effect_group {
+			passive_effect name="EntityDamage" operation="base_set" value="35"
+		}
+	}
+}
+`))
+	if err != nil {
+		t.Fatalf("NewFromKdl: %v", err)
+	}
+
+	want := []EntityChange{
+		{Kind: ChangeModified, Type: "item", Key: "gunPistol", Path: "/items/item[@name='gunPistol']", Properties: []PropertyChange{
+			{Kind: ChangeModified, Name: "Tags", Old: "gun", New: "gun,pistol"},
+			{Kind: ChangeModified, Name: "effect_group[1]/passive_effect[@name='EntityDamage'][@operation='base_set']/@value", Old: "32", New: "35"},
+		}},
+		{Kind: ChangeRemoved, Type: "item", Key: "oldGun", Path: "/items/item[@name='oldGun']"},
+		{Kind: ChangeAdded, Type: "item", Key: "newGun", Path: "/items/item[@name='newGun']", Properties: []PropertyChange{
+			{Kind: ChangeAdded, Name: "Tags", New: "x"},
+		}},
+	}
+	if got := Diff(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff mismatch:\ngot  %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,203 @@
+package ko
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/sblinch/kdl-go/document"
+)
+
+// IdentityRule says which attributes identify an element among its
+// siblings, which is how diffs, merges and patches match elements between
+// two versions of a file.
+type IdentityRule struct {
+	// File limits the rule to one config file, by base name without
+	// extension such as "recipes". Empty matches every file.
+	File string
+	// Path is the element path from the root element, such as
+	// "recipes/recipe". A "*" step matches any one element and "//" matches
+	// any number of them, so "//property" matches property at any depth.
+	Path string
+	// Keys are the identifying attributes. The first must be present for
+	// the rule to apply; the others are part of the identity whether present
+	// or not.
+	Keys []string
+}
+
+// Registry holds identity rules. When several rules match an element, the
+// one added last wins, so rules added to DefaultRegistry override it.
+type Registry struct {
+	rules []IdentityRule
+}
+
+// defaultRules describe the vanilla config files. The catch-all name rule
+// comes first so the more specific ones override it.
+var defaultRules = []IdentityRule{
+	{Path: "//*", Keys: []string{"name"}},
+	{Path: "//property", Keys: []string{"class"}},
+	{Path: "//property", Keys: []string{"name"}},
+	{Path: "//passive_effect", Keys: []string{"name", "operation"}},
+	{File: "recipes", Path: "recipes/recipe", Keys: []string{"name", "craft_area"}},
+	{File: "loot", Path: "lootcontainers/lootgroup", Keys: []string{"name"}},
+	{File: "loot", Path: "lootcontainers/lootgroup/item", Keys: []string{"group"}},
+	{File: "loot", Path: "lootcontainers/lootgroup/item", Keys: []string{"name"}},
+	{File: "loot", Path: "lootcontainers/lootcontainer", Keys: []string{"id"}},
+	{File: "loot", Path: "lootcontainers/lootcontainer", Keys: []string{"name"}},
+	{File: "progression", Path: "progression/perks/perk", Keys: []string{"name"}},
+}
+
+// DefaultRegistry returns a registry holding the built-in rules for the
+// vanilla 7 Days to Die config files.
+func DefaultRegistry() *Registry {
+	return NewRegistry(defaultRules...)
+}
+
+// NewRegistry returns a registry holding only rules.
+func NewRegistry(rules ...IdentityRule) *Registry {
+	r := &Registry{}
+	r.Add(rules...)
+	return r
+}
+
+// Add appends rules, which take precedence over those already held.
+func (r *Registry) Add(rules ...IdentityRule) {
+	r.rules = append(r.rules, rules...)
+}
+
+// Rules returns the rules in precedence order, lowest first.
+func (r *Registry) Rules() []IdentityRule {
+	return append([]IdentityRule(nil), r.rules...)
+}
+
+// Key returns the identity of an element with the given attributes at path,
+// the element names from the root element down to and including it, in
+// file. ok is false when no rule applies and the element can only be matched
+// by position.
+func (r *Registry) Key(file string, path []string, attrs map[string]string) (Key, bool) {
+	for i := len(r.rules) - 1; i >= 0; i-- {
+		rule := r.rules[i]
+		if len(rule.Keys) == 0 || (rule.File != "" && file != "" && !strings.EqualFold(rule.File, file)) {
+			continue
+		}
+		if _, ok := attrs[rule.Keys[0]]; !ok || !matchPath(splitPattern(rule.Path), path) {
+			continue
+		}
+		key := make(Key, len(rule.Keys))
+		for j, attr := range rule.Keys {
+			v, ok := attrs[attr]
+			key[j] = KeyPart{Attr: attr, Value: v, Missing: !ok}
+		}
+		return key, true
+	}
+	return nil, false
+}
+
+// KeyPart is one identifying attribute of an element.
+type KeyPart struct {
+	Attr    string
+	Value   string
+	Missing bool
+}
+
+// Key identifies an element among its siblings.
+type Key []KeyPart
+
+// String renders the key for people: the first value, followed by the other
+// parts that are present, such as "bread (craft_area=campfire)".
+func (k Key) String() string {
+	if len(k) == 0 {
+		return ""
+	}
+	var extra []string
+	for _, p := range k[1:] {
+		if !p.Missing {
+			extra = append(extra, p.Attr+"="+p.Value)
+		}
+	}
+	if len(extra) == 0 {
+		return k[0].Value
+	}
+	return k[0].Value + " (" + strings.Join(extra, ", ") + ")"
+}
+
+// Predicate renders the key as XPath predicates that select exactly the
+// element it identifies, such as [@name='bread'][not(@craft_area)].
+func (k Key) Predicate() string {
+	var b strings.Builder
+	for _, p := range k {
+		if p.Missing {
+			b.WriteString("[not(@" + p.Attr + ")]")
+		} else {
+			b.WriteString("[@" + p.Attr + "=" + xpathLiteral(p.Value) + "]")
+		}
+	}
+	return b.String()
+}
+
+func splitPattern(pattern string) []string {
+	var steps []string
+	for i, s := range strings.Split(pattern, "/") {
+		if s == "" {
+			// Leading "//" or an inner "//" both leave an empty step.
+			if i > 0 || strings.HasPrefix(pattern, "//") {
+				if len(steps) == 0 || steps[len(steps)-1] != "**" {
+					steps = append(steps, "**")
+				}
+			}
+			continue
+		}
+		steps = append(steps, s)
+	}
+	return steps
+}
+
+func matchPath(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchPath(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 || (pattern[0] != "*" && pattern[0] != path[0]) {
+		return false
+	}
+	return matchPath(pattern[1:], path[1:])
+}
+
+// SetSource records the file the document was read from, which selects the
+// identity rules that apply to it.
+func (e *Ko) SetSource(path string) {
+	base := filepath.Base(path)
+	e.file = strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// SetRegistry changes the identity rules used to match the document's
+// elements. nil restores the default registry.
+func (e *Ko) SetRegistry(r *Registry) {
+	e.ids = r
+}
+
+func (e *Ko) registry() *Registry {
+	if e.ids == nil {
+		return defaultRegistry
+	}
+	return e.ids
+}
+
+var defaultRegistry = DefaultRegistry()
+
+// key returns the identity of n, whose ancestors from the root element are
+// parents.
+func (e *Ko) key(parents []string, n *document.Node) (Key, bool) {
+	attrs := make(map[string]string, len(n.Properties))
+	for k, v := range n.Properties {
+		attrs[k] = v.ValueString()
+	}
+	path := append(append(make([]string, 0, len(parents)+1), parents...), n.Name.NodeNameString())
+	return e.registry().Key(e.file, path, attrs)
+}
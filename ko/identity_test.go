@@ -0,0 +1,59 @@
+package ko
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryKey(t *testing.T) {
+	r := DefaultRegistry()
+
+	key, ok := r.Key("recipes", []string{"recipes", "recipe"}, map[string]string{"name": "foodBread", "count": "1"})
+	if !ok || key.Predicate() != "[@name='foodBread'][not(@craft_area)]" || key.String() != "foodBread" {
+		t.Errorf("recipe without craft area: %v %q %q", ok, key.Predicate(), key.String())
+	}
+	key, _ = r.Key("recipes", []string{"recipes", "recipe"}, map[string]string{"name": "foodBread", "craft_area": "campfire"})
+	if key.String() != "foodBread (craft_area=campfire)" {
+		t.Errorf("recipe with craft area: %q", key.String())
+	}
+
+	key, ok = r.Key("items", []string{"items", "item", "property", "property"}, map[string]string{"name": "Delay", "value": "1"})
+	if !ok || key.Predicate() != "[@name='Delay']" {
+		t.Errorf("nested property: %v %q", ok, key.Predicate())
+	}
+	key, ok = r.Key("items", []string{"items", "item", "property"}, map[string]string{"class": "Action0"})
+	if !ok || key.Predicate() != "[@class='Action0']" {
+		t.Errorf("property class: %v %q", ok, key.Predicate())
+	}
+	if _, ok := r.Key("items", []string{"items", "item", "effect_group", "triggered_effect"}, map[string]string{"action": "AddBuff"}); ok {
+		t.Error("triggered_effect without a name should have no key")
+	}
+
+	r.Add(IdentityRule{File: "items", Path: "items/item/effect_group/triggered_effect", Keys: []string{"action"}})
+	if key, ok := r.Key("items", []string{"items", "item", "effect_group", "triggered_effect"}, map[string]string{"action": "AddBuff"}); !ok || key.String() != "AddBuff" {
+		t.Errorf("added rule not used: %v %q", ok, key.String())
+	}
+	if _, ok := r.Key("buffs", []string{"items", "item", "effect_group", "triggered_effect"}, map[string]string{"action": "AddBuff"}); ok {
+		t.Error("file specific rule applied to another file")
+	}
+}
+
+func TestRegistryDrivesCompare(t *testing.T) {
+	read := func(s string) *Ko {
+		k, err := NewFromXml(strings.NewReader(s))
+		if err != nil {
+			t.Fatalf("NewFromXml: %v", err)
+		}
+		k.SetSource("recipes.xml")
+		return k
+	}
+	a := read(`<recipes><recipe name="foodBread" craft_area="campfire" count="1"/><recipe name="foodBread" count="2"/></recipes>`)
+	b := read(`<recipes><recipe name="foodBread" count="2"/><recipe name="foodBread" craft_area="campfire" count="3"/></recipes>`)
+
+	opts := CompareOptions{IgnoreKeyedOrder: true}
+	diffs := Compare(a, b, opts)
+	want := "/recipes/recipe[@name='foodBread'][@craft_area='campfire']/@count"
+	if len(diffs) != 1 || diffs[0].Path != want {
+		t.Errorf("Compare = %v, want one difference at %s", diffs, want)
+	}
+}
@@ -0,0 +1,308 @@
+package ko
+
+import (
+	"fmt"
+
+	"github.com/sblinch/kdl-go/document"
+)
+
+// conflictNodeIdentifier names the marker nodes Merge leaves where it could
+// not decide.
+const conflictNodeIdentifier = "_conflict"
+
+// Kinds of Conflict.
+const (
+	// ConflictAttribute is an attribute both sides changed differently.
+	ConflictAttribute = "attribute"
+	// ConflictText is element text both sides changed differently.
+	ConflictText = "text"
+	// ConflictDeleted is an element one side removed and the other changed.
+	ConflictDeleted = "deleted"
+)
+
+// Conflict is a change Merge could not resolve. Path locates it like a
+// Difference path. Base, Ours and Theirs hold the three values of an
+// attribute or text conflict, empty where that version has none; for a
+// deleted conflict, By names the side that removed the element.
+type Conflict struct {
+	Kind   string
+	Path   string
+	Base   string
+	Ours   string
+	Theirs string
+	By     string
+}
+
+func (c Conflict) String() string {
+	if c.Kind == ConflictDeleted {
+		return fmt.Sprintf("%s: changed in %s, deleted in %s", c.Path, otherSide(c.By), c.By)
+	}
+	return fmt.Sprintf("%s: %s conflict: base %q, ours %q, theirs %q", c.Path, c.Kind, c.Base, c.Ours, c.Theirs)
+}
+
+func otherSide(side string) string {
+	if side == "ours" {
+		return "theirs"
+	}
+	return "ours"
+}
+
+// Merge combines the changes ours and theirs each made to base. Elements are
+// matched by identity, as in Compare, and merged attribute by attribute, so
+// changes to different entities or different attributes of one entity merge
+// cleanly. Elements added on one side are placed after the sibling they
+// follow there. Comments are taken from ours.
+//
+// Where both sides changed the same thing differently, the result keeps
+// ours and the element gets a _conflict child recording all three versions,
+// such as _conflict kind="attribute" name="value" base="30" ours="32"
+// theirs="35". An element one side deleted and the other changed is kept,
+// marked _conflict kind="deleted" by="theirs". The conflicts are also
+// returned.
+func Merge(base, ours, theirs *Ko) (*Ko, []Conflict) {
+	m := &merger{base: base, ours: ours, theirs: theirs}
+	doc := &document.Document{
+		Nodes: m.children("", nil, base.doc.Nodes, ours.doc.Nodes, theirs.doc.Nodes),
+	}
+	return &Ko{doc: doc, opts: ours.opts, file: ours.file, ids: ours.ids}, m.conflicts
+}
+
+type merger struct {
+	base, ours, theirs *Ko
+	conflicts          []Conflict
+}
+
+// mergeChild is one element of a merged child list.
+type mergeChild struct {
+	id   string
+	node *document.Node
+}
+
+// children merges three versions of a child list at path, whose ancestors
+// are parents. Non-element nodes come from ours.
+func (m *merger) children(path string, parents []string, bn, on, tn []*document.Node) []*document.Node {
+	counts := m.base.unkeyedCounts(parents, bn)
+	for _, c := range []map[string]int{m.ours.unkeyedCounts(parents, on), m.theirs.unkeyedCounts(parents, tn)} {
+		for name, n := range c {
+			if n > counts[name] {
+				counts[name] = n
+			}
+		}
+	}
+	_, bElems := m.base.identifyWith(parents, bn, counts)
+	oIDs, oElems := m.ours.identifyWith(parents, on, counts)
+	tIDs, tElems := m.theirs.identifyWith(parents, tn, counts)
+
+	// Walk ours, keeping its comments, text and charset in place.
+	var out []mergeChild
+	i := 0
+	for _, n := range on {
+		if isSpecialNode(n.Name.NodeNameString()) {
+			out = append(out, mergeChild{node: n})
+			continue
+		}
+		id := oIDs[i]
+		i++
+		if merged := m.element(path+"/"+id, parents, bElems[id], n, tElems[id]); merged != nil {
+			out = append(out, mergeChild{id: id, node: merged})
+		}
+	}
+
+	// Elements only theirs has: either added there, or deleted by ours.
+	for j, id := range tIDs {
+		if _, ok := oElems[id]; ok {
+			continue
+		}
+		merged := m.element(path+"/"+id, parents, bElems[id], nil, tElems[id])
+		if merged == nil {
+			continue
+		}
+		at := insertionPoint(out, tIDs[:j])
+		out = append(out[:at], append([]mergeChild{{id: id, node: merged}}, out[at:]...)...)
+	}
+
+	nodes := make([]*document.Node, len(out))
+	for i, c := range out {
+		nodes[i] = c.node
+	}
+	return nodes
+}
+
+// insertionPoint returns where in out to insert an element that follows the
+// elements before in its own version: after the last of those kept in out,
+// else before the first element of out.
+func insertionPoint(out []mergeChild, before []string) int {
+	for j := len(before) - 1; j >= 0; j-- {
+		for i, c := range out {
+			if c.id == before[j] {
+				return i + 1
+			}
+		}
+	}
+	for i, c := range out {
+		if c.id != "" {
+			return i
+		}
+	}
+	return len(out)
+}
+
+// element merges the versions of one element. Any of them may be nil when
+// that version lacks it; nil is returned when the merge removes it.
+func (m *merger) element(path string, parents []string, b, o, t *document.Node) *document.Node {
+	switch {
+	case o != nil && t != nil:
+		return m.node(path, parents, b, o, t)
+	case b == nil && o != nil:
+		return o
+	case b == nil && t != nil:
+		return t
+	case o != nil:
+		if sameElement(parents, m.base, b, m.ours, o) {
+			return nil
+		}
+		return m.deleted(path, o, "theirs")
+	case t != nil:
+		if sameElement(parents, m.base, b, m.theirs, t) {
+			return nil
+		}
+		return m.deleted(path, t, "ours")
+	default:
+		return nil
+	}
+}
+
+// deleted keeps n, which side removed while the other changed it, marked as
+// a conflict.
+func (m *merger) deleted(path string, n *document.Node, by string) *document.Node {
+	m.conflicts = append(m.conflicts, Conflict{Kind: ConflictDeleted, Path: path, By: by})
+	marked := *n
+	marked.Children = append([]*document.Node{conflictNode(map[string]string{"kind": ConflictDeleted, "by": by})}, n.Children...)
+	return &marked
+}
+
+// node merges an element both sides have; b is nil when both added it.
+func (m *merger) node(path string, parents []string, b, o, t *document.Node) *document.Node {
+	var markers []*document.Node
+	merged := &document.Node{
+		Name:       o.Name,
+		Type:       o.Type,
+		Properties: make(document.Properties),
+		Arguments:  o.Arguments,
+	}
+
+	var bProps document.Properties
+	if b != nil {
+		bProps = b.Properties
+	}
+	for _, k := range unionKeys(unionProperties(bProps, o.Properties), t.Properties) {
+		bv, ov, tv := bProps[k], o.Properties[k], t.Properties[k]
+		v, ok := merge3(bv, ov, tv)
+		if !ok {
+			c := Conflict{Kind: ConflictAttribute, Path: path + "/@" + k, Base: valueString(bv), Ours: valueString(ov), Theirs: valueString(tv)}
+			m.conflicts = append(m.conflicts, c)
+			markers = append(markers, conflictNode(withValues(map[string]string{"kind": ConflictAttribute, "name": k}, bv, ov, tv)))
+			v = ov
+		}
+		if v != nil {
+			merged.Properties[k] = v
+		}
+	}
+
+	c := &comparer{}
+	var bText string
+	var bChildren []*document.Node
+	if b != nil {
+		bText, bChildren = c.text(b.Arguments, b.Children), b.Children
+	}
+	oText, tText := c.text(o.Arguments, o.Children), c.text(t.Arguments, t.Children)
+	oChildren := o.Children
+	switch {
+	case oText == tText || tText == bText:
+	case oText == bText:
+		merged.Arguments = t.Arguments
+		oChildren = append(textNodes(t.Children), stripText(oChildren)...)
+	default:
+		cf := Conflict{Kind: ConflictText, Path: path + "/text()", Base: bText, Ours: oText, Theirs: tText}
+		m.conflicts = append(m.conflicts, cf)
+		attrs := map[string]string{"kind": ConflictText, "ours": oText, "theirs": tText}
+		if b != nil {
+			attrs["base"] = bText
+		}
+		markers = append(markers, conflictNode(attrs))
+	}
+
+	children := m.children(path, appendPath(parents, o), bChildren, oChildren, t.Children)
+	merged.Children = append(markers, children...)
+	return merged
+}
+
+// merge3 picks the merged value of one attribute, nil meaning absent, and
+// reports false when both sides changed it differently.
+func merge3(b, o, t *document.Value) (*document.Value, bool) {
+	switch {
+	case valueEqual(o, t), valueEqual(t, b):
+		return o, true
+	case valueEqual(o, b):
+		return t, true
+	default:
+		return nil, false
+	}
+}
+
+func valueEqual(a, b *document.Value) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.ValueString() == b.ValueString()
+}
+
+func valueString(v *document.Value) string {
+	if v == nil {
+		return ""
+	}
+	return v.ValueString()
+}
+
+// withValues adds the base, ours and theirs values present to attrs.
+func withValues(attrs map[string]string, b, o, t *document.Value) map[string]string {
+	for k, v := range map[string]*document.Value{"base": b, "ours": o, "theirs": t} {
+		if v != nil {
+			attrs[k] = v.ValueString()
+		}
+	}
+	return attrs
+}
+
+func conflictNode(attrs map[string]string) *document.Node {
+	return newElement(conflictNodeIdentifier, attrs, nil)
+}
+
+func textNodes(children []*document.Node) []*document.Node {
+	var out []*document.Node
+	for _, n := range children {
+		if n.Name.NodeNameString() == textNodeIdentifier {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func unionProperties(a, b document.Properties) document.Properties {
+	out := make(document.Properties, len(a)+len(b))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		out[k] = v
+	}
+	return out
+}
+
+// sameElement reports whether a, read by ak, and b, read by bk, hold the
+// same data apart from comments and attribute order.
+func sameElement(parents []string, ak *Ko, a *document.Node, bk *Ko, b *document.Node) bool {
+	c := &comparer{a: ak, b: bk, opts: CompareOptions{IgnoreComments: true, IgnoreAttributeOrder: true}}
+	c.element("", parents, a, b)
+	return len(c.diffs) == 0
+}
@@ -0,0 +1,104 @@
+package ko
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	base := mustXml(t, `<items>
+	<item name="gunPistol">
+		<property name="EntityDamage" value="30"/>
+		<property name="Weight" value="1"/>
+		<property name="Tags" value="gun"/>
+	</item>
+	<item name="knife"><property name="EntityDamage" value="5"/></item>
+	<item name="club"><property name="EntityDamage" value="8"/></item>
+	<item name="bat"/>
+</items>`)
+	ours := mustXml(t, `<items>
+	<item name="gunPistol">
+		<property name="EntityDamage" value="32"/>
+		<property name="Weight" value="1"/>
+		<property name="Tags" value="gun,modded"/>
+	</item>
+	<item name="knife"><property name="EntityDamage" value="6"/></item>
+	<item name="bat"/>
+	<item name="ourSword"/>
+</items>`)
+	theirs := mustXml(t, `<items>
+	<item name="gunPistol">
+		<property name="EntityDamage" value="30"/>
+		<property name="Weight" value="2"/>
+		<property name="Tags" value="gun,vanilla"/>
+	</item>
+	<item name="vanillaBow"/>
+	<item name="club"><property name="EntityDamage" value="9"/></item>
+	<item name="bat"/>
+</items>`)
+
+	merged, conflicts := Merge(base, ours, theirs)
+
+	wantConflicts := []Conflict{
+		{Kind: ConflictAttribute, Path: "/items/item[@name='gunPistol']/property[@name='Tags']/@value", Base: "gun", Ours: "gun,modded", Theirs: "gun,vanilla"},
+		{Kind: ConflictDeleted, Path: "/items/item[@name='knife']", By: "theirs"},
+		{Kind: ConflictDeleted, Path: "/items/item[@name='club']", By: "ours"},
+	}
+	if !reflect.DeepEqual(conflicts, wantConflicts) {
+		t.Errorf("conflicts:\n%+v\nwant:\n%+v", conflicts, wantConflicts)
+	}
+
+	var b bytes.Buffer
+	if err := merged.ToKdl(&b); err != nil {
+		t.Fatalf("ToKdl: %v", err)
+	}
+	want := `items {
+  item name="gunPistol" {
+    property name="EntityDamage" value="32"
+    property name="Weight" value="2"
+    property name="Tags" value="gun,modded" {
+      "_conflict" name="value" base="gun" kind="attribute" ours="gun,modded" theirs="gun,vanilla"
+    }
+  }
+  item name="vanillaBow"
+  item name="club" {
+    "_conflict" by="ours" kind="deleted"
+    property name="EntityDamage" value="9"
+  }
+  item name="knife" {
+    "_conflict" by="theirs" kind="deleted"
+    property name="EntityDamage" value="6"
+  }
+  item name="bat"
+  item name="ourSword"
+}
+`
+	if b.String() != want {
+		t.Errorf("merged:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestMergeClean(t *testing.T) {
+	base := mustXml(t, `<items><item name="a"><property name="x" value="1"/></item></items>`)
+	ours := mustXml(t, `<items><item name="a"><property name="x" value="1"/><property name="y" value="2"/></item></items>`)
+	theirs := mustXml(t, `<items><item name="a"><property name="x" value="3"/></item><item name="b"/></items>`)
+	merged, conflicts := Merge(base, ours, theirs)
+	if len(conflicts) != 0 {
+		t.Fatalf("conflicts: %v", conflicts)
+	}
+	want := mustXml(t, `<items><item name="a"><property name="x" value="3"/><property name="y" value="2"/></item><item name="b"/></items>`)
+	if diffs := Compare(merged, want, CompareOptions{IgnoreAttributeOrder: true}); len(diffs) > 0 {
+		t.Errorf("merged differs from the expected result: %v", diffs)
+	}
+}
+
+func mustXml(t *testing.T, s string) *Ko {
+	t.Helper()
+	k, err := NewFromXml(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("NewFromXml: %v", err)
+	}
+	return k
+}
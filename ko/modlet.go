@@ -0,0 +1,296 @@
+package ko
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sblinch/kdl-go/document"
+)
+
+// Names of the XPath patch operations of a 7 Days to Die modlet.
+const (
+	OpSet             = "set"
+	OpSetAttribute    = "setattribute"
+	OpRemoveAttribute = "removeattribute"
+	OpRemove          = "remove"
+	OpAppend          = "append"
+	OpPrepend         = "prepend"
+	OpInsertAfter     = "insertAfter"
+	OpInsertBefore    = "insertBefore"
+	OpCSV             = "csv"
+)
+
+// Values of the op attribute of a csv operation.
+const (
+	CSVAdd    = "add"
+	CSVRemove = "remove"
+)
+
+// modletRoot is the root element of a modlet config file.
+const modletRoot = "configs"
+
+// GenerateModlet returns a modlet config file whose patch operations turn
+// vanilla into edited. Elements are matched by identity, as in Diff, and
+// the operations address them by key, such as
+// /items/item[@name='gunPistol']/property[@name='EntityDamage']/@value, so
+// they keep applying when the game reorders or adds entities. Unkeyed
+// elements are addressed by position.
+//
+// Changed attributes become set, or csv when they hold comma-separated
+// lists whose members were only added or removed; new attributes become
+// setattribute and dropped ones removeattribute; changed text becomes set.
+// Added elements are appended to their parent when they come after every
+// element vanilla also has, and otherwise inserted after, or before, their
+// nearest matched sibling. Reordering matched elements is not expressed.
+func GenerateModlet(vanilla, edited *Ko) (*Ko, error) {
+	vr, err := rootElement(vanilla)
+	if err != nil {
+		return nil, fmt.Errorf("vanilla: %w", err)
+	}
+	er, err := rootElement(edited)
+	if err != nil {
+		return nil, fmt.Errorf("edited: %w", err)
+	}
+	if vr.Name.NodeNameString() != er.Name.NodeNameString() {
+		return nil, fmt.Errorf("root elements differ: %s and %s", vr.Name.NodeNameString(), er.Name.NodeNameString())
+	}
+
+	g := &modletGenerator{vanilla: vanilla, edited: edited}
+	g.element("/"+vr.Name.NodeNameString(), nil, vr, er)
+	root := newElement(modletRoot, nil, g.ops)
+	return &Ko{doc: &document.Document{Nodes: []*document.Node{root}}, opts: edited.opts}, nil
+}
+
+// rootElement returns the single root element of k.
+func rootElement(k *Ko) (*document.Node, error) {
+	var root *document.Node
+	for _, n := range k.doc.Nodes {
+		if isSpecialNode(n.Name.NodeNameString()) {
+			continue
+		}
+		if root != nil {
+			return nil, errors.New("more than one root element")
+		}
+		root = n
+	}
+	if root == nil {
+		return nil, errors.New("no root element")
+	}
+	return root, nil
+}
+
+type modletGenerator struct {
+	vanilla, edited *Ko
+	ops             []*document.Node
+}
+
+func (g *modletGenerator) op(name, xpath string, attrs map[string]string, children ...*document.Node) {
+	props := map[string]string{"xpath": xpath}
+	for k, v := range attrs {
+		props[k] = v
+	}
+	g.ops = append(g.ops, newElement(name, props, children))
+}
+
+// element adds the operations that turn v, the vanilla element at xpath,
+// into e. parents are the ancestors of both.
+func (g *modletGenerator) element(xpath string, parents []string, v, e *document.Node) {
+	for _, k := range unionKeys(v.Properties, e.Properties) {
+		vv, vok := v.Properties[k]
+		ev, eok := e.Properties[k]
+		switch {
+		case !eok:
+			g.op(OpRemoveAttribute, xpath+"/@"+k, nil)
+		case !vok:
+			g.op(OpSetAttribute, xpath, map[string]string{"name": k}, textNode(ev.ValueString()))
+		case vv.ValueString() != ev.ValueString():
+			removed, added, ok := csvChange(vv.ValueString(), ev.ValueString())
+			if !ok {
+				g.op(OpSet, xpath+"/@"+k, nil, textNode(ev.ValueString()))
+				break
+			}
+			if len(removed) > 0 {
+				g.op(OpCSV, xpath+"/@"+k, map[string]string{"op": CSVRemove, "delim": csvDelim}, textNode(strings.Join(removed, csvDelim)))
+			}
+			if len(added) > 0 {
+				g.op(OpCSV, xpath+"/@"+k, map[string]string{"op": CSVAdd, "delim": csvDelim}, textNode(strings.Join(added, csvDelim)))
+			}
+		}
+	}
+
+	c := &comparer{}
+	if vt, et := c.text(v.Arguments, v.Children), c.text(e.Arguments, e.Children); vt != et {
+		g.op(OpSet, xpath, nil, textNode(et))
+	}
+
+	g.children(xpath, appendPath(parents, v), stripText(v.Children), stripText(e.Children))
+}
+
+// children adds the operations that turn vn, the vanilla children of the
+// element at xpath, into en. Unkeyed steps are positions, so they are taken
+// from the children as they stand when each operation applies: removals go
+// first, last to first, and each insertion is tracked in cur.
+func (g *modletGenerator) children(xpath string, parents []string, vn, en []*document.Node) {
+	vIDs, vElems := identify(g.vanilla, g.edited, parents, vn, en)
+	eIDs, eElems := identify(g.edited, g.vanilla, parents, en, vn)
+	steps := xpathSteps(g.vanilla, parents, vn)
+
+	removed := make(map[*document.Node]bool)
+	for i := len(vIDs) - 1; i >= 0; i-- {
+		id := vIDs[i]
+		if _, ok := eElems[id]; !ok {
+			g.op(OpRemove, xpath+"/"+steps[vElems[id]], nil)
+			removed[vElems[id]] = true
+		}
+	}
+	var cur []*document.Node
+	for _, n := range vn {
+		if !removed[n] {
+			cur = append(cur, n)
+		}
+	}
+	if len(removed) > 0 {
+		steps = xpathSteps(g.vanilla, parents, cur)
+	}
+
+	// Walk edited, recursing into matched elements and gathering each run
+	// of added ones to insert next to a matched sibling.
+	var run []*document.Node
+	var prev *document.Node
+	flush := func(next *document.Node) {
+		at := len(cur)
+		switch {
+		case len(run) == 0:
+			return
+		case next == nil:
+			g.op(OpAppend, xpath, nil, run...)
+		case prev != nil:
+			g.op(OpInsertAfter, xpath+"/"+steps[prev], nil, run...)
+			at = indexOf(cur, prev) + 1
+		default:
+			g.op(OpInsertBefore, xpath+"/"+steps[next], nil, run...)
+			at = indexOf(cur, next)
+		}
+		cur = append(cur[:at], append(append([]*document.Node{}, run...), cur[at:]...)...)
+		steps = xpathSteps(g.vanilla, parents, cur)
+		run = nil
+	}
+	for _, id := range eIDs {
+		vn, ok := vElems[id]
+		if !ok {
+			run = append(run, eElems[id])
+			continue
+		}
+		flush(vn)
+		g.element(xpath+"/"+steps[vn], parents, vn, eElems[id])
+		prev = vn
+	}
+	flush(nil)
+}
+
+// indexOf returns the index of n in nodes, or -1.
+func indexOf(nodes []*document.Node, n *document.Node) int {
+	for i, m := range nodes {
+		if m == n {
+			return i
+		}
+	}
+	return -1
+}
+
+// xpathSteps returns an XPath step selecting each element among nodes, read
+// by k: its name and key predicates where it has a key, else its position
+// among all siblings of the same name when it has any.
+func xpathSteps(k *Ko, parents []string, nodes []*document.Node) map[*document.Node]string {
+	total := make(map[string]int)
+	for _, n := range nodes {
+		total[n.Name.NodeNameString()]++
+	}
+	steps := make(map[*document.Node]string)
+	pos := make(map[string]int)
+	seen := make(map[string]int)
+	for _, n := range nodes {
+		name := n.Name.NodeNameString()
+		if isSpecialNode(name) {
+			continue
+		}
+		pos[name]++
+		if key, ok := k.key(parents, n); ok {
+			step := name + key.Predicate()
+			seen[step]++
+			if seen[step] > 1 {
+				step = fmt.Sprintf("%s[%d]", step, seen[step])
+			}
+			steps[n] = step
+		} else if total[name] > 1 {
+			steps[n] = fmt.Sprintf("%s[%d]", name, pos[name])
+		} else {
+			steps[n] = name
+		}
+	}
+	return steps
+}
+
+// csvDelim is the list separator csv operations default to.
+const csvDelim = ","
+
+// csvChange reports whether new is old with some members removed and
+// others appended, treating both as comma-separated lists, and if so which.
+// Values that are not lists of distinct words, such as "0,0.5,0", are not
+// treated as lists.
+func csvChange(old, new string) (removed, added []string, ok bool) {
+	if !strings.Contains(old, csvDelim) && !strings.Contains(new, csvDelim) {
+		return nil, nil, false
+	}
+	om, nm := csvMembers(old, csvDelim), csvMembers(new, csvDelim)
+	if !csvWords(om) || !csvWords(nm) {
+		return nil, nil, false
+	}
+	kept := csvRemove(om, nm, true)
+	removed = csvRemove(om, kept, false)
+	added = csvRemove(nm, om, false)
+	if strings.Join(csvAdd(kept, added), csvDelim) != new {
+		return nil, nil, false
+	}
+	return removed, added, true
+}
+
+// csvWords reports whether members are distinct and not all numbers.
+func csvWords(members []string) bool {
+	seen := make(map[string]bool)
+	numbers := 0
+	for _, m := range members {
+		if seen[m] {
+			return false
+		}
+		seen[m] = true
+		if _, err := strconv.ParseFloat(m, 64); err == nil {
+			numbers++
+		}
+	}
+	return numbers < len(members)
+}
+
+func newElement(name string, attrs map[string]string, children []*document.Node) *document.Node {
+	n := &document.Node{
+		Name:       &document.Value{Value: name},
+		Properties: make(document.Properties, len(attrs)),
+		Arguments:  []*document.Value{},
+		Children:   append([]*document.Node{}, children...),
+	}
+	for k, v := range attrs {
+		n.Properties[k] = &document.Value{Value: v}
+	}
+	return n
+}
+
+func textNode(s string) *document.Node {
+	return &document.Node{
+		Name:       &document.Value{Value: textNodeIdentifier},
+		Properties: make(document.Properties),
+		Arguments:  []*document.Value{{Value: s}},
+		Children:   []*document.Node{},
+	}
+}
@@ -0,0 +1,92 @@
+package ko
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGenerateModlet(t *testing.T) {
+	vanilla := mustXml(t, `<items>
+	<item name="gunPistol">
+		<property name="EntityDamage" value="30"/>
+		<property name="Tags" value="gun,pistol"/>
+		<effect_group><triggered_effect trigger="onSelfAttack"/></effect_group>
+	</item>
+	<item name="knife"/>
+	<item name="club" weight="2"/>
+</items>`)
+	edited := mustXml(t, `<items>
+	<item name="newFirst"/>
+	<item name="gunPistol">
+		<property name="EntityDamage" value="32"/>
+		<property name="Tags" value="gun,pistol,perkGunslinger" param1="x"/>
+		<property name="Weight" value="3"/>
+		<effect_group><triggered_effect trigger="onSelfAttack"/><triggered_effect trigger="onSelfHit"/></effect_group>
+	</item>
+	<item name="club"/>
+	<item name="newLast"/>
+</items>`)
+
+	modlet, err := GenerateModlet(vanilla, edited)
+	if err != nil {
+		t.Fatalf("GenerateModlet: %v", err)
+	}
+	var b bytes.Buffer
+	if err := modlet.ToXml(&b); err != nil {
+		t.Fatalf("ToXml: %v", err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<configs>
+  <remove xpath="/items/item[@name='knife']"/>
+  <insertBefore xpath="/items/item[@name='gunPistol']">
+    <item name="newFirst"/>
+  </insertBefore>
+  <set xpath="/items/item[@name='gunPistol']/property[@name='EntityDamage']/@value">32</set>
+  <setattribute name="param1" xpath="/items/item[@name='gunPistol']/property[@name='Tags']">x</setattribute>
+  <csv delim="," op="add" xpath="/items/item[@name='gunPistol']/property[@name='Tags']/@value">perkGunslinger</csv>
+  <insertAfter xpath="/items/item[@name='gunPistol']/property[@name='Tags']">
+    <property name="Weight" value="3"/>
+  </insertAfter>
+  <append xpath="/items/item[@name='gunPistol']/effect_group">
+    <triggered_effect trigger="onSelfHit"/>
+  </append>
+  <removeattribute xpath="/items/item[@name='club']/@weight"/>
+  <append xpath="/items">
+    <item name="newLast"/>
+  </append>
+</configs>`
+	if b.String() != want {
+		t.Errorf("modlet:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestCSVChange(t *testing.T) {
+	tests := []struct {
+		old, new       string
+		removed, added string
+		ok             bool
+	}{
+		{"gun,pistol", "gun,pistol,perkGunslinger", "", "perkGunslinger", true},
+		{"gun", "gun,pistol", "", "pistol", true},
+		{"gun,pistol,ranged", "gun,ranged,melee", "pistol", "melee", true},
+		{"gun,pistol", "pistol,gun", "", "", false},
+		{"0,0.5,0", "0,1,0", "", "", false},
+		{"1,2", "1,3", "", "", false},
+		{"gun, pistol", "gun, pistol, x", "", "", false},
+		{"30", "32", "", "", false},
+	}
+	for _, tt := range tests {
+		removed, added, ok := csvChange(tt.old, tt.new)
+		if ok != tt.ok || strings.Join(removed, ",") != tt.removed || strings.Join(added, ",") != tt.added {
+			t.Errorf("csvChange(%q, %q) = %q, %q, %v; want %q, %q, %v", tt.old, tt.new, removed, added, ok, tt.removed, tt.added, tt.ok)
+		}
+	}
+}
+
+func TestGenerateModletRootMismatch(t *testing.T) {
+	_, err := GenerateModlet(mustXml(t, `<items/>`), mustXml(t, `<blocks/>`))
+	if err == nil {
+		t.Error("GenerateModlet accepted documents with different roots")
+	}
+}
@@ -0,0 +1,557 @@
+package ko
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sblinch/kdl-go/document"
+)
+
+// PatchResult reports what one operation of a modlet did.
+type PatchResult struct {
+	// Index is the 1-based position of the operation in the patch.
+	Index int
+	Op    string
+	XPath string
+	// Matched is the number of nodes the XPath selected. The game logs a
+	// warning for operations that match nothing.
+	Matched int
+	// Line is the line of the operation in the patch file, 0 when unknown.
+	Line int
+	// Branch is, for a conditional, the cond of the branch taken, "else",
+	// or empty when none was. The results of the operations in the branch
+	// follow.
+	Branch string
+}
+
+// ApplyPatch applies the operations of patch, a modlet config file such as
+//
+//	<configs>
+//	  <set xpath="/items/item[@name='gunPistol']/property[@name='EntityDamage']/@value">32</set>
+//	</configs>
+//
+// to the document, the way the game's modlet loader does:
+//
+//   - set replaces the value of the selected attributes, or the content of
+//     the selected elements, with its text
+//   - setattribute sets the attribute named by its name attribute on the
+//     selected elements
+//   - append and prepend add its elements at the end or start of the
+//     selected elements, or its text to the end or start of the selected
+//     attributes
+//   - insertBefore and insertAfter add its elements as siblings before or
+//     after the selected elements
+//   - remove deletes the selected elements or attributes
+//   - removeattribute deletes the selected attributes
+//   - csv with op="add" or op="remove" adds its members to, or removes
+//     them from, the list held by the selected attributes or elements, split
+//     on its delim attribute, a comma by default; members already present are
+//     not added again
+//   - conditional applies the operations of its first if or elseif branch
+//     whose cond holds, else those of its else branch, if any
+//
+// Each operation sees the document as left by the ones before it, and is
+// recorded as the Origin of the elements and attributes it changes; see
+// Blame and Options.Provenance. A malformed operation stops the patch with an
+// error; one whose XPath matches nothing is only reported in its result.
+// Conditions are evaluated with no mods loaded and no game version; use
+// ApplyPatchEnv to supply them.
+func (e *Ko) ApplyPatch(patch *Ko) ([]PatchResult, error) {
+	return e.ApplyPatchEnv(patch, PatchEnv{})
+}
+
+// ApplyPatchEnv is ApplyPatch with the conditions of conditional blocks
+// evaluated against env.
+func (e *Ko) ApplyPatchEnv(patch *Ko, env PatchEnv) ([]PatchResult, error) {
+	root, err := rootElement(patch)
+	if err != nil {
+		return nil, fmt.Errorf("patch: %w", err)
+	}
+	p := &patcher{target: e, source: patch, tree: newXTree(e.doc.Nodes), env: env}
+	defer func() { e.doc.Nodes = p.tree.root.Children }()
+
+	err = p.ops(root.Children)
+	return p.results, err
+}
+
+// Kinds of PatchIssue.
+const (
+	// IssueInvalid is an operation that cannot be applied: it is malformed,
+	// its XPath or cond does not parse, or it fails when applied.
+	IssueInvalid = "invalid"
+	// IssueNoMatch is an operation whose XPath matches nothing.
+	IssueNoMatch = "no-match"
+	// IssueMultiMatch is an operation whose XPath names one node by key
+	// yet matches several.
+	IssueMultiMatch = "multi-match"
+)
+
+// PatchIssue is a problem CheckPatch found with one operation of a patch.
+type PatchIssue struct {
+	Kind string
+	// Line is the line of the operation in the patch file, 0 when unknown.
+	Line    int
+	Op      string
+	XPath   string
+	Matched int
+	// Err is why an IssueInvalid operation cannot be applied.
+	Err error
+}
+
+func (i PatchIssue) String() string {
+	op := i.Op
+	if i.XPath != "" && (i.Kind != IssueInvalid || !strings.Contains(i.Err.Error(), i.XPath)) {
+		op += " " + i.XPath
+	}
+	switch i.Kind {
+	case IssueInvalid:
+		return fmt.Sprintf("%s: %s: %v", i.Kind, op, i.Err)
+	case IssueNoMatch:
+		return fmt.Sprintf("%s: %s matches nothing", i.Kind, op)
+	default:
+		return fmt.Sprintf("%s: %s matches %d nodes, not one", i.Kind, op, i.Matched)
+	}
+}
+
+// CheckPatch applies patch like ApplyPatchEnv, but instead of stopping at
+// the first malformed operation it reports every problem it finds: invalid
+// operations, XPaths that match nothing, and XPaths that name a single node,
+// such as /items/item[@name='gunPistol']/@tags, yet match several. Invalid
+// operations are skipped. The branches of conditional blocks that env does
+// not select are checked for invalid operations and conditions only, since
+// they are not meant to match.
+func (e *Ko) CheckPatch(patch *Ko, env PatchEnv) ([]PatchIssue, error) {
+	root, err := rootElement(patch)
+	if err != nil {
+		return nil, fmt.Errorf("patch: %w", err)
+	}
+	p := &patcher{target: e, source: patch, tree: newXTree(e.doc.Nodes), env: env, check: true}
+	defer func() { e.doc.Nodes = p.tree.root.Children }()
+
+	p.ops(root.Children)
+	return p.issues, nil
+}
+
+type patcher struct {
+	target, source *Ko
+	tree           *xtree
+	env            PatchEnv
+	results        []PatchResult
+	// check collects issues rather than stopping at invalid operations;
+	// dry then only parses operations, without applying them.
+	check, dry bool
+	issues     []PatchIssue
+}
+
+func (p *patcher) issue(kind string, op *document.Node, res PatchResult, err error) {
+	p.issues = append(p.issues, PatchIssue{
+		Kind:    kind,
+		Line:    p.source.lines[op],
+		Op:      op.Name.NodeNameString(),
+		XPath:   res.XPath,
+		Matched: res.Matched,
+		Err:     err,
+	})
+}
+
+// ops applies a list of operations, recording their results.
+func (p *patcher) ops(ops []*document.Node) error {
+	for _, op := range ops {
+		name := op.Name.NodeNameString()
+		if isSpecialNode(name) {
+			continue
+		}
+		var err error
+		var res PatchResult
+		var branch *document.Node
+		if name == OpConditional {
+			res, branch, err = p.conditional(op)
+		} else {
+			res, err = p.apply(op)
+		}
+		res.Index, res.Line = len(p.results)+1, p.source.lines[op]
+		if err != nil {
+			if !p.check {
+				return fmt.Errorf("operation %d (%s): %w", res.Index, name, err)
+			}
+			p.issue(IssueInvalid, op, res, err)
+			continue
+		}
+		p.results = append(p.results, res)
+		if p.check && !p.dry && name != OpConditional {
+			p.checkMatches(op, res)
+		}
+		if branch != nil {
+			err = p.ops(branch.Children)
+			if err != nil {
+				return err
+			}
+		}
+		if p.check && name == OpConditional {
+			p.untaken(op, branch)
+		}
+	}
+	return nil
+}
+
+// checkMatches records an issue when the XPath of op, applied with result
+// res, matched nothing, or several nodes where it names one.
+func (p *patcher) checkMatches(op *document.Node, res PatchResult) {
+	switch {
+	case res.Matched == 0:
+		p.issue(IssueNoMatch, op, res, nil)
+	case res.Matched > 1:
+		x, err := compileXPath(res.XPath)
+		if err == nil && x.namesOne() {
+			p.issue(IssueMultiMatch, op, res, nil)
+		}
+	}
+}
+
+// untaken checks the branches of the conditional op other than taken
+// without applying them: their conditions must parse and their operations
+// must be valid.
+func (p *patcher) untaken(op, taken *document.Node) {
+	dry := p.dry
+	p.dry = true
+	defer func() { p.dry = dry }()
+	for _, b := range op.Children {
+		if b == taken || isSpecialNode(b.Name.NodeNameString()) {
+			continue
+		}
+		if cond, ok := b.Properties["cond"]; ok {
+			_, err := evalCondition(cond.ValueString(), p.env, p.tree)
+			if err != nil {
+				p.issue(IssueInvalid, b, PatchResult{}, err)
+			}
+		}
+		p.ops(b.Children)
+	}
+}
+
+// conditional picks the branch of a conditional block to apply and returns
+// it, or nil when none holds.
+func (p *patcher) conditional(op *document.Node) (PatchResult, *document.Node, error) {
+	res := PatchResult{Op: OpConditional}
+	var taken *document.Node
+	branches, afterElse := 0, false
+	for _, b := range op.Children {
+		name := b.Name.NodeNameString()
+		if isSpecialNode(name) {
+			continue
+		}
+		branches++
+		switch {
+		case afterElse:
+			return res, nil, fmt.Errorf("%s after else", name)
+		case name == condIf && branches == 1, name == condElseIf && branches > 1:
+			cond, ok := b.Properties["cond"]
+			if !ok {
+				return res, nil, fmt.Errorf("%s without a cond attribute", name)
+			}
+			if res.Branch != "" {
+				continue
+			}
+			holds, err := evalCondition(cond.ValueString(), p.env, p.tree)
+			if err != nil {
+				return res, nil, err
+			}
+			if holds {
+				taken, res.Branch = b, cond.ValueString()
+			}
+		case name == condElse && branches > 1:
+			afterElse = true
+			if res.Branch == "" {
+				taken, res.Branch = b, condElse
+			}
+		default:
+			return res, nil, fmt.Errorf("unexpected %s; a conditional holds an if, then any elseif and an optional else", name)
+		}
+	}
+	return res, taken, nil
+}
+
+func (p *patcher) apply(op *document.Node) (PatchResult, error) {
+	name := op.Name.NodeNameString()
+	res := PatchResult{Op: name}
+	xv, ok := op.Properties["xpath"]
+	if !ok {
+		return res, fmt.Errorf("missing xpath attribute")
+	}
+	res.XPath = xv.ValueString()
+	if !knownOp(name) {
+		return res, fmt.Errorf("unknown operation")
+	}
+	x, err := compileXPath(res.XPath)
+	if err != nil {
+		return res, err
+	}
+	nodes, err := p.tree.selectNodes(x)
+	if err != nil {
+		return res, err
+	}
+	res.Matched = len(nodes)
+	if p.dry {
+		return res, nil
+	}
+
+	c := &comparer{}
+	text := c.text(op.Arguments, op.Children)
+	origin := Origin{Mod: p.env.Mod, Patch: p.env.Patch, Line: p.source.lines[op], Op: name}
+	for _, n := range nodes {
+		if n.n == p.tree.root {
+			return res, fmt.Errorf("cannot %s the document itself", name)
+		}
+		switch name {
+		case OpSet:
+			if n.attr != "" {
+				n.n.Properties[n.attr] = &document.Value{Value: text}
+				p.target.touchAttr(n.n, n.attr, origin)
+			} else {
+				n.n.Arguments = []*document.Value{}
+				n.n.Children = []*document.Node{textNode(text)}
+				p.tree.adopt(n.n, n.n.Children)
+				p.target.touch(n.n, origin)
+			}
+		case OpSetAttribute:
+			attr, ok := op.Properties["name"]
+			if !ok {
+				return res, fmt.Errorf("missing name attribute")
+			}
+			if n.attr != "" {
+				return res, fmt.Errorf("xpath selects an attribute, not an element")
+			}
+			p.setAttribute(n.n, attr.ValueString(), text)
+			p.target.touchAttr(n.n, attr.ValueString(), origin)
+		case OpAppend, OpPrepend:
+			if n.attr != "" {
+				v := n.n.Properties[n.attr].ValueString()
+				if name == OpAppend {
+					v += text
+				} else {
+					v = text + v
+				}
+				n.n.Properties[n.attr] = &document.Value{Value: v}
+				p.target.touchAttr(n.n, n.attr, origin)
+				continue
+			}
+			content := p.content(op, origin)
+			if name == OpAppend {
+				n.n.Children = append(n.n.Children, content...)
+			} else {
+				n.n.Children = append(content, n.n.Children...)
+			}
+			p.tree.adopt(n.n, content)
+		case OpInsertBefore, OpInsertAfter:
+			if n.attr != "" {
+				return res, fmt.Errorf("xpath selects an attribute, not an element")
+			}
+			p.insert(n.n, p.content(op, origin), name == OpInsertAfter)
+		case OpRemove, OpRemoveAttribute:
+			if n.attr != "" {
+				p.removeAttribute(n.n, n.attr)
+				p.target.touchAttr(n.n, n.attr, origin)
+			} else if name == OpRemoveAttribute {
+				attr, ok := op.Properties["name"]
+				if !ok {
+					return res, fmt.Errorf("xpath selects an element and there is no name attribute")
+				}
+				p.removeAttribute(n.n, attr.ValueString())
+				p.target.touchAttr(n.n, attr.ValueString(), origin)
+			} else if parent := p.tree.parent[n.n]; parent != nil {
+				p.target.recordRemoval(p.tree, n.n, origin)
+				p.remove(n.n)
+				if parent != p.tree.root {
+					p.target.touch(parent, origin)
+				}
+			}
+		case OpCSV:
+			err := p.csv(op, n, text)
+			if err != nil {
+				return res, err
+			}
+			if n.attr != "" {
+				p.target.touchAttr(n.n, n.attr, origin)
+			} else {
+				p.target.touch(n.n, origin)
+			}
+		}
+	}
+	return res, nil
+}
+
+// csv applies a csv operation with the given text to n.
+func (p *patcher) csv(op *document.Node, n xnode, text string) error {
+	delim := csvDelim
+	if d, ok := op.Properties["delim"]; ok && d.ValueString() != "" {
+		delim = d.ValueString()
+	}
+	mode := ""
+	if m, ok := op.Properties["op"]; ok {
+		mode = m.ValueString()
+	}
+	members := csvMembers(p.tree.stringValue(n), delim)
+	change := csvMembers(text, delim)
+	switch mode {
+	case CSVAdd:
+		members = csvAdd(members, change)
+	case CSVRemove:
+		members = csvRemove(members, change, false)
+	default:
+		return fmt.Errorf("csv op must be %s or %s, got %q", CSVAdd, CSVRemove, mode)
+	}
+	value := strings.Join(members, delim)
+	if n.attr != "" {
+		n.n.Properties[n.attr] = &document.Value{Value: value}
+	} else {
+		n.n.Arguments = []*document.Value{}
+		n.n.Children = append(stripText(n.n.Children), textNode(value))
+		p.tree.adopt(n.n, n.n.Children)
+	}
+	return nil
+}
+
+// csvMembers splits s on delim, trimming each member and dropping empty
+// ones.
+func csvMembers(s, delim string) []string {
+	var out []string
+	for _, m := range strings.Split(s, delim) {
+		if m = strings.TrimSpace(m); m != "" {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// csvAdd appends the members of add not already in members.
+func csvAdd(members, add []string) []string {
+	out := append([]string(nil), members...)
+	for _, a := range add {
+		if !contains(out, a) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// csvRemove returns members without those in drop, or with keep set only
+// those also in drop.
+func csvRemove(members, drop []string, keep bool) []string {
+	var out []string
+	for _, m := range members {
+		if contains(drop, m) == keep {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func knownOp(name string) bool {
+	switch name {
+	case OpSet, OpSetAttribute, OpAppend, OpPrepend, OpInsertBefore, OpInsertAfter, OpRemove, OpRemoveAttribute, OpCSV:
+		return true
+	}
+	return false
+}
+
+// content returns copies of the nodes op adds, its elements and comments,
+// with origin recorded as having added them.
+func (p *patcher) content(op *document.Node, origin Origin) []*document.Node {
+	var out []*document.Node
+	for _, c := range op.Children {
+		if c.Name.NodeNameString() == textNodeIdentifier {
+			continue
+		}
+		out = append(out, p.clone(c, origin))
+	}
+	return out
+}
+
+// clone deep-copies n from the patch, keeping the attribute order it was
+// read with, and records origin as having added each element.
+func (p *patcher) clone(n *document.Node, origin Origin) *document.Node {
+	c := &document.Node{
+		Name:       n.Name,
+		Type:       n.Type,
+		Arguments:  append([]*document.Value{}, n.Arguments...),
+		Properties: make(document.Properties, len(n.Properties)),
+		Children:   make([]*document.Node, 0, len(n.Children)),
+	}
+	for k, v := range n.Properties {
+		c.Properties[k] = v
+	}
+	for _, child := range n.Children {
+		c.Children = append(c.Children, p.clone(child, origin))
+	}
+	if !isSpecialNode(n.Name.NodeNameString()) {
+		p.target.touch(c, origin)
+	}
+	if order, ok := p.source.attrOrder[n]; ok && p.target.attrOrder != nil {
+		p.target.attrOrder[c] = append([]string(nil), order...)
+	}
+	return c
+}
+
+func (p *patcher) setAttribute(n *document.Node, attr, value string) {
+	if n.Properties == nil {
+		n.Properties = make(document.Properties)
+	}
+	if _, ok := n.Properties[attr]; !ok {
+		if order, ok := p.target.attrOrder[n]; ok {
+			p.target.attrOrder[n] = append(order, attr)
+		}
+	}
+	n.Properties[attr] = &document.Value{Value: value}
+}
+
+func (p *patcher) removeAttribute(n *document.Node, attr string) {
+	delete(n.Properties, attr)
+	if order, ok := p.target.attrOrder[n]; ok {
+		kept := order[:0:0]
+		for _, a := range order {
+			if a != attr {
+				kept = append(kept, a)
+			}
+		}
+		p.target.attrOrder[n] = kept
+	}
+}
+
+func (p *patcher) insert(at *document.Node, nodes []*document.Node, after bool) {
+	parent := p.tree.parent[at]
+	for i, c := range parent.Children {
+		if c != at {
+			continue
+		}
+		if after {
+			i++
+		}
+		rest := append(append([]*document.Node{}, nodes...), parent.Children[i:]...)
+		parent.Children = append(parent.Children[:i], rest...)
+		break
+	}
+	p.tree.adopt(parent, nodes)
+}
+
+func (p *patcher) remove(n *document.Node) {
+	parent := p.tree.parent[n]
+	if parent == nil {
+		return // already removed with an ancestor
+	}
+	for i, c := range parent.Children {
+		if c == n {
+			parent.Children = append(parent.Children[:i], parent.Children[i+1:]...)
+			break
+		}
+	}
+	delete(p.tree.parent, n)
+}
@@ -0,0 +1,285 @@
+package ko
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	doc := mustXml(t, `<items>
+	<item name="gunPistol" tags="gun">
+		<property name="EntityDamage" value="30"/>
+		<property name="Weight" value="1"/>
+	</item>
+	<item name="knife"/>
+	<item name="club"/>
+</items>`)
+	patch := mustXml(t, `<configs>
+	<set xpath="/items/item[@name='gunPistol']/property[@name='EntityDamage']/@value">32</set>
+	<setattribute xpath="/items/item[@name='knife']" name="tags">blade</setattribute>
+	<append xpath="/items/item[@name='gunPistol']/@tags">,pistol</append>
+	<append xpath="/items/item[@name='gunPistol']"><property name="Stack" value="5"/></append>
+	<prepend xpath="/items/item[@name='gunPistol']"><property name="Group" value="guns"/></prepend>
+	<insertBefore xpath="/items/item[@name='knife']"><item name="spear"/></insertBefore>
+	<insertAfter xpath="/items/item[@name='knife']"><item name="axe"/><item name="bow"/></insertAfter>
+	<remove xpath="/items/item[@name='club']"/>
+	<removeattribute xpath="/items/item[@name='gunPistol']/property[@name='Weight']/@value"/>
+	<remove xpath="/items/item[@name='doesNotExist']"/>
+</configs>`)
+
+	results, err := doc.ApplyPatch(patch)
+	if err != nil {
+		t.Fatalf("ApplyPatch: %v", err)
+	}
+	var matched []int
+	for _, r := range results {
+		matched = append(matched, r.Matched)
+	}
+	if want := []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 0}; !reflect.DeepEqual(matched, want) {
+		t.Errorf("matched = %v, want %v", matched, want)
+	}
+
+	var b bytes.Buffer
+	if err := doc.ToXml(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<items>
+  <item name="gunPistol" tags="gun,pistol">
+    <property name="Group" value="guns"/>
+    <property name="EntityDamage" value="32"/>
+    <property name="Weight"/>
+    <property name="Stack" value="5"/>
+  </item>
+  <item name="spear"/>
+  <item name="knife" tags="blade"/>
+  <item name="axe"/>
+  <item name="bow"/>
+</items>`
+	if b.String() != want {
+		t.Errorf("patched:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestApplyPatchCSV(t *testing.T) {
+	doc := mustXml(t, `<items>
+	<item name="gunPistol"><property name="Tags" value="gun,pistol,ranged"/></item>
+	<item name="knife"><property name="Tags" value="melee"/><tags>blade;steel</tags></item>
+</items>`)
+	patch := mustXml(t, `<configs>
+	<csv xpath="//property[@name='Tags']/@value" op="add" delim=",">perkGunslinger, ranged</csv>
+	<csv xpath="/items/item[@name='gunPistol']/property[@name='Tags']/@value" op="remove">pistol</csv>
+	<csv xpath="/items/item[@name='knife']/tags" op="remove" delim=";">steel</csv>
+</configs>`)
+	if _, err := doc.ApplyPatch(patch); err != nil {
+		t.Fatalf("ApplyPatch: %v", err)
+	}
+	want := mustXml(t, `<items>
+	<item name="gunPistol"><property name="Tags" value="gun,ranged,perkGunslinger"/></item>
+	<item name="knife"><property name="Tags" value="melee,perkGunslinger,ranged"/><tags>blade</tags></item>
+</items>`)
+	if diffs := Compare(doc, want, CompareOptions{IgnoreAttributeOrder: true}); len(diffs) > 0 {
+		t.Errorf("patched document differs: %v", diffs)
+	}
+
+	bad := mustXml(t, `<configs><csv xpath="//property/@value" op="replace">x</csv></configs>`)
+	if _, err := doc.ApplyPatch(bad); err == nil {
+		t.Error("ApplyPatch accepted csv op=\"replace\"")
+	}
+}
+
+func TestApplyPatchConditional(t *testing.T) {
+	patch := `<configs>
+	<conditional>
+		<if cond="mod_loaded('SCore')">
+			<set xpath="/items/item/@tags">score</set>
+		</if>
+		<elseif cond="game_version >= '1.1'">
+			<set xpath="/items/item/@tags">new</set>
+			<conditional>
+				<if cond="xpath('/items/item[@tags=&quot;new&quot;]')"><append xpath="/items"><item name="nested"/></append></if>
+			</conditional>
+		</elseif>
+		<else>
+			<set xpath="/items/item/@tags">old</set>
+		</else>
+	</conditional>
+	<conditional>
+		<if cond="not mod_loaded('Other')"><setattribute xpath="/items/item" name="x">1</setattribute></if>
+	</conditional>
+</configs>`
+	for _, tt := range []struct {
+		env      PatchEnv
+		branches []string
+		ops      []string
+		tags     string
+	}{
+		{PatchEnv{Mods: map[string]string{"SCore": ""}}, []string{"mod_loaded('SCore')", "not mod_loaded('Other')"}, []string{"conditional", "set", "conditional", "setattribute"}, "score"},
+		{PatchEnv{GameVersion: "V1.2 b27"}, []string{"game_version >= '1.1'", `xpath('/items/item[@tags="new"]')`, "not mod_loaded('Other')"}, []string{"conditional", "set", "conditional", "append", "conditional", "setattribute"}, "new"},
+		{PatchEnv{GameVersion: "1.0", Mods: map[string]string{"Other": "2"}}, []string{"else", ""}, []string{"conditional", "set", "conditional"}, "old"},
+	} {
+		doc := mustXml(t, `<items><item name="a" tags="vanilla"/></items>`)
+		results, err := doc.ApplyPatchEnv(mustXml(t, patch), tt.env)
+		if err != nil {
+			t.Fatalf("ApplyPatchEnv(%+v): %v", tt.env, err)
+		}
+		var branches, ops []string
+		for i, r := range results {
+			if r.Index != i+1 {
+				t.Errorf("result %d has index %d", i, r.Index)
+			}
+			ops = append(ops, r.Op)
+			if r.Op == OpConditional {
+				branches = append(branches, r.Branch)
+			}
+		}
+		if !reflect.DeepEqual(branches, tt.branches) || !reflect.DeepEqual(ops, tt.ops) {
+			t.Errorf("%+v: branches %q, ops %q; want %q, %q", tt.env, branches, ops, tt.branches, tt.ops)
+		}
+		if tags := doc.doc.Nodes[0].Children[0].Properties["tags"].ValueString(); tags != tt.tags {
+			t.Errorf("%+v: tags = %q, want %q", tt.env, tags, tt.tags)
+		}
+	}
+
+	for _, bad := range []string{
+		`<configs><conditional><else/></conditional></configs>`,
+		`<configs><conditional><if/></conditional></configs>`,
+		`<configs><conditional><if cond="true"/><else/><elseif cond="true"/></conditional></configs>`,
+		`<configs><conditional><if cond="mod_loaded("/></conditional></configs>`,
+	} {
+		doc := mustXml(t, `<items/>`)
+		if _, err := doc.ApplyPatch(mustXml(t, bad)); err == nil {
+			t.Errorf("ApplyPatch(%s) succeeded", bad)
+		}
+	}
+}
+
+func TestApplyPatchErrors(t *testing.T) {
+	for _, patch := range []string{
+		`<configs><frobnicate xpath="/items"/></configs>`,
+		`<configs><set>1</set></configs>`,
+		`<configs><set xpath="/items/item[">1</set></configs>`,
+		`<configs><insertAfter xpath="/items/item/@name"><item/></insertAfter></configs>`,
+	} {
+		doc := mustXml(t, `<items><item name="a"/></items>`)
+		if _, err := doc.ApplyPatch(mustXml(t, patch)); err == nil {
+			t.Errorf("ApplyPatch(%s) succeeded", patch)
+		}
+	}
+}
+
+func TestCheckPatch(t *testing.T) {
+	doc := mustXml(t, `<items>
+	<item name="gunPistol"><property name="Tags" value="gun"/></item>
+	<item name="knife"/>
+	<item name="knife"/>
+</items>`)
+	patch := mustXml(t, `<configs>
+	<set xpath="/items/item[@name='gunPistol']/property[@name='Tags']/@value">gun,pistol</set>
+	<remove xpath="/items/item[@name='club']"/>
+	<setattribute xpath="/items/item[@name='knife']" name="tags">blade</setattribute>
+	<set xpath="//item/@tags">blade</set>
+	<set xpath="/items/item[">1</set>
+	<frobnicate xpath="/items"/>
+	<conditional>
+		<if cond="game_version >= '1.0'">
+			<remove xpath="/items/item[@name='gunPistol']"/>
+		</if>
+		<elseif cond="mod_loaded(">
+			<remove xpath="/items/item[@name='old']"/>
+			<set xpath="/items/item[@name=">1</set>
+		</elseif>
+	</conditional>
+	<set xpath="/items/item[@name='gunPistol']/@tags">gone</set>
+</configs>`)
+
+	issues, err := doc.CheckPatch(patch, PatchEnv{GameVersion: "1.2"})
+	if err != nil {
+		t.Fatalf("CheckPatch: %v", err)
+	}
+	var got []string
+	for _, i := range issues {
+		got = append(got, fmt.Sprintf("%d %s %s", i.Line, i.Kind, i.Op))
+	}
+	want := []string{
+		"3 no-match remove",
+		"4 multi-match setattribute",
+		"6 invalid set",
+		"7 invalid frobnicate",
+		"12 invalid elseif",
+		"14 invalid set",
+		"17 no-match set",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("issues:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if s := issues[1].String(); s != "multi-match: setattribute /items/item[@name='knife'] matches 2 nodes, not one" {
+		t.Errorf("String() = %q", s)
+	}
+
+	for _, tt := range []struct {
+		xpath string
+		one   bool
+	}{
+		{"/items/item[@name='a']/property[@name='b']/@value", true},
+		{"/items/item[@name='a' and @class='b']", true},
+		{"/items/item[2]", true},
+		{"/items", true},
+		{"/items/item", false},
+		{"//item[@name='a']", false},
+		{"/items/item[starts-with(@name, 'a')]", false},
+		{"/items/*[@name='a']", false},
+	} {
+		x, err := compileXPath(tt.xpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if one := x.namesOne(); one != tt.one {
+			t.Errorf("namesOne(%s) = %v, want %v", tt.xpath, one, tt.one)
+		}
+	}
+}
+
+// A generated modlet applied to vanilla must give back the edited file.
+func TestGenerateApplyRoundTrip(t *testing.T) {
+	for _, tc := range []struct{ name, vanilla, edited string }{
+		{"keyed", `<items>
+	<item name="a"><property name="x" value="1"/><effect_group><triggered_effect trigger="t1"/></effect_group></item>
+	<item name="b" weight="2"><property name="y" value="2"/><property name="Tags" value="a,b,c"/></item>
+	<item name="c"/>
+</items>`, `<items>
+	<item name="first"/>
+	<item name="a"><property name="x" value="5"/><property name="z" value="9"/><effect_group><triggered_effect trigger="t1"/><triggered_effect trigger="t2"/></effect_group></item>
+	<item name="b"><property name="y" value="2" param1="p"/><property name="Tags" value="a,c,d"/></item>
+	<item name="d"><property name="w" value="0"/></item>
+</items>`},
+		// Positions of unkeyed items count their keyed siblings, so they
+		// shift as keyed ones are removed or inserted.
+		{"mixed", `<items><item name="a"/><item v="1"/><item v="2"/></items>`,
+			`<items><item v="1"/><item v="5"/></items>`},
+		{"inserted", `<items><item name="a"/><item v="1"/><item v="2"/><item v="3"/></items>`,
+			`<items><item name="a"/><item name="b"/><item v="1"/><item v="5"/></items>`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			edited := mustXml(t, tc.edited)
+			modlet, err := GenerateModlet(mustXml(t, tc.vanilla), edited)
+			if err != nil {
+				t.Fatalf("GenerateModlet: %v", err)
+			}
+			var patch bytes.Buffer
+			if err := modlet.ToXml(&patch); err != nil {
+				t.Fatal(err)
+			}
+			patched := mustXml(t, tc.vanilla)
+			if _, err := patched.ApplyPatch(mustXml(t, patch.String())); err != nil {
+				t.Fatalf("ApplyPatch: %v", err)
+			}
+			if diffs := Compare(patched, edited, CompareOptions{IgnoreAttributeOrder: true}); len(diffs) > 0 {
+				t.Errorf("patched vanilla differs from edited:\n%v\npatch:\n%s", diffs, patch.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package ko
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sblinch/kdl-go/document"
+)
+
+// Origin is a patch operation that changed part of a document.
+type Origin struct {
+	// Mod and Patch name the mod and patch file the operation came from, as
+	// given in PatchEnv.
+	Mod   string
+	Patch string
+	// Line is the line of the operation in the patch file, 0 when unknown.
+	Line int
+	Op   string
+}
+
+func (o Origin) String() string {
+	var b strings.Builder
+	if o.Mod != "" {
+		b.WriteString(o.Mod + " ")
+	}
+	if o.Patch != "" {
+		b.WriteString(o.Patch)
+		if o.Line > 0 {
+			fmt.Fprintf(&b, ":%d", o.Line)
+		}
+		b.WriteString(" ")
+	}
+	b.WriteString(o.Op)
+	return b.String()
+}
+
+// nodeOrigins records the operations that changed one element: those that
+// added it, set its text or removed its children, and those that changed
+// each of its attributes.
+type nodeOrigins struct {
+	node  []Origin
+	attrs map[string][]Origin
+}
+
+// touch records that o changed n itself.
+func (e *Ko) touch(n *document.Node, o Origin) {
+	e.originsOf(n).node = append(e.originsOf(n).node, o)
+}
+
+// touchAttr records that o changed the attribute attr of n.
+func (e *Ko) touchAttr(n *document.Node, attr string, o Origin) {
+	no := e.originsOf(n)
+	if no.attrs == nil {
+		no.attrs = make(map[string][]Origin)
+	}
+	no.attrs[attr] = append(no.attrs[attr], o)
+}
+
+func (e *Ko) originsOf(n *document.Node) *nodeOrigins {
+	if e.origins == nil {
+		e.origins = make(map[*document.Node]*nodeOrigins)
+	}
+	no, ok := e.origins[n]
+	if !ok {
+		no = &nodeOrigins{}
+		e.origins[n] = no
+	}
+	return no
+}
+
+// annotation describes the origins of n and of its attributes that are
+// gone, for a KDL node annotation.
+func (no *nodeOrigins) annotation(n *document.Node) string {
+	if no == nil {
+		return ""
+	}
+	parts := []string{}
+	if len(no.node) > 0 {
+		parts = append(parts, joinOrigins(no.node))
+	}
+	for _, attr := range no.attrNames() {
+		if _, ok := n.Properties[attr]; !ok {
+			parts = append(parts, "@"+attr+": "+joinOrigins(no.attrs[attr]))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// attrAnnotation describes the origins of attr, for a KDL property
+// annotation.
+func (no *nodeOrigins) attrAnnotation(attr string) string {
+	if no == nil {
+		return ""
+	}
+	return joinOrigins(no.attrs[attr])
+}
+
+// comment describes all the origins of the element, for an XML comment.
+func (no *nodeOrigins) comment() string {
+	if no == nil {
+		return ""
+	}
+	parts := []string{}
+	if len(no.node) > 0 {
+		parts = append(parts, joinOrigins(no.node))
+	}
+	for _, attr := range no.attrNames() {
+		parts = append(parts, "@"+attr+": "+joinOrigins(no.attrs[attr]))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	// XML comments cannot hold "--".
+	return " " + strings.ReplaceAll(strings.Join(parts, "; "), "--", "- -") + " "
+}
+
+func (no *nodeOrigins) attrNames() []string {
+	names := make([]string, 0, len(no.attrs))
+	for attr := range no.attrs {
+		names = append(names, attr)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func joinOrigins(origins []Origin) string {
+	s := make([]string, len(origins))
+	for i, o := range origins {
+		s[i] = o.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// Blame is the provenance of one node a patch changed.
+type Blame struct {
+	// Path locates the node by key, such as
+	// /items/item[@name='gunPistol']/@tags.
+	Path string
+	// Value is the text of the element or the value of the attribute.
+	Value string
+	// Origins are the operations that changed the node, in the order they
+	// were applied.
+	Origins []Origin
+	// Attrs holds, for an element, the operations that changed each of its
+	// attributes, including ones since removed.
+	Attrs map[string][]Origin
+	// Removed reports that the element is gone from the document; the last
+	// of Origins removed it.
+	Removed bool
+}
+
+// Blame reports which patch operations applied to the document changed the
+// nodes xpath selects, followed by those it selects among removed elements,
+// each as it was when removed.
+func (e *Ko) Blame(xpath string) ([]Blame, error) {
+	x, err := compileXPath(xpath)
+	if err != nil {
+		return nil, err
+	}
+	t := newXTree(e.doc.Nodes)
+	nodes, err := t.selectNodes(x)
+	if err != nil {
+		return nil, err
+	}
+	var out []Blame
+	for _, n := range nodes {
+		if n.n == t.root {
+			continue
+		}
+		b := Blame{Path: e.pathOf(t, n.n), Value: t.stringValue(n)}
+		no := e.origins[n.n]
+		if n.attr != "" {
+			b.Path += "/@" + n.attr
+			if no != nil {
+				b.Origins = no.attrs[n.attr]
+			}
+		} else if no != nil {
+			b.Origins, b.Attrs = no.node, no.attrs
+		}
+		out = append(out, b)
+	}
+	for _, r := range e.removals {
+		removed, err := e.blameRemoved(x, r)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, removed...)
+	}
+	return out, nil
+}
+
+// blameRemoved returns the Blame of the nodes x selects in the subtree r
+// removed, evaluated with r back under copies of its ancestors.
+func (e *Ko) blameRemoved(x *xpath, r removal) ([]Blame, error) {
+	top := r.node
+	for i := len(r.ancestors) - 1; i >= 0; i-- {
+		a := r.ancestors[i]
+		top = &document.Node{Name: a.Name, Properties: a.Properties, Arguments: a.Arguments, Children: []*document.Node{top}}
+	}
+	t := newXTree([]*document.Node{top})
+	nodes, err := t.selectNodes(x)
+	if err != nil {
+		return nil, err
+	}
+	var out []Blame
+	prefix := e.pathOf(t, r.node)
+	for _, n := range nodes {
+		if !t.within(n.n, r.node) {
+			continue
+		}
+		b := Blame{Path: r.path + strings.TrimPrefix(e.pathOf(t, n.n), prefix), Value: t.stringValue(n), Removed: true}
+		no := e.origins[n.n]
+		if n.attr != "" {
+			b.Path += "/@" + n.attr
+			if no != nil {
+				b.Origins = append(b.Origins, no.attrs[n.attr]...)
+			}
+		} else if no != nil {
+			b.Origins, b.Attrs = append(b.Origins, no.node...), no.attrs
+		}
+		b.Origins = append(b.Origins, r.origin)
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+// pathOf returns an XPath locating n by the keys of it and its ancestors.
+func (e *Ko) pathOf(t *xtree, n *document.Node) string {
+	var chain []*document.Node
+	for c := n; c != nil && c != t.root; c = t.parent[c] {
+		chain = append([]*document.Node{c}, chain...)
+	}
+	var path strings.Builder
+	var parents []string
+	siblings := t.root.Children
+	for _, c := range chain {
+		step, ok := xpathSteps(e, parents, siblings)[c]
+		if !ok {
+			step = "text()"
+		}
+		path.WriteString("/" + step)
+		parents = appendPath(parents, c)
+		siblings = c.Children
+	}
+	return path.String()
+}
+
+// Touched returns the Blame of every element patches changed, in document
+// order, followed by those changed and later removed, in the order they
+// were removed.
+func (e *Ko) Touched() []Blame {
+	t := newXTree(e.doc.Nodes)
+	var out []Blame
+	var walk func(nodes []*document.Node)
+	walk = func(nodes []*document.Node) {
+		for _, n := range nodes {
+			if no, ok := e.origins[n]; ok && !isSpecialNode(n.Name.NodeNameString()) {
+				out = append(out, Blame{Path: e.pathOf(t, n), Origins: no.node, Attrs: no.attrs})
+			}
+			walk(n.Children)
+		}
+	}
+	walk(e.doc.Nodes)
+	return append(out, e.removed...)
+}
+
+// removal is an element a patch removed, with its ancestors and path at
+// the time.
+type removal struct {
+	node      *document.Node
+	ancestors []*document.Node
+	path      string
+	origin    Origin
+}
+
+// recordRemoval keeps n, which o is about to remove, and the provenance of
+// n and of the changed elements under it.
+func (e *Ko) recordRemoval(t *xtree, n *document.Node, o Origin) {
+	var ancestors []*document.Node
+	for p := t.parent[n]; p != nil && p != t.root; p = t.parent[p] {
+		ancestors = append([]*document.Node{p}, ancestors...)
+	}
+	path := e.pathOf(t, n)
+	e.removals = append(e.removals, removal{node: n, ancestors: ancestors, path: path, origin: o})
+	b := Blame{Path: path, Removed: true}
+	if no, ok := e.origins[n]; ok {
+		b.Origins, b.Attrs = append(b.Origins, no.node...), no.attrs
+	}
+	b.Origins = append(b.Origins, o)
+	e.removed = append(e.removed, b)
+	for _, c := range n.Children {
+		e.recordChangedRemoval(t, c, o)
+	}
+}
+
+// recordChangedRemoval keeps the provenance of the changed elements of the
+// subtree at n, which o is about to remove.
+func (e *Ko) recordChangedRemoval(t *xtree, n *document.Node, o Origin) {
+	if no, ok := e.origins[n]; ok {
+		origins := append(append([]Origin(nil), no.node...), o)
+		e.removed = append(e.removed, Blame{Path: e.pathOf(t, n), Origins: origins, Attrs: no.attrs, Removed: true})
+	}
+	for _, c := range n.Children {
+		e.recordChangedRemoval(t, c, o)
+	}
+}
@@ -0,0 +1,146 @@
+package ko
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBlame(t *testing.T) {
+	doc := mustXml(t, `<items>
+	<item name="gunPistol" tags="gun">
+		<property name="EntityDamage" value="30"/>
+		<property name="Weight" value="1"/>
+	</item>
+</items>`)
+	core := mustXml(t, `<configs>
+	<set xpath="//property[@name='EntityDamage']/@value">35</set>
+	<append xpath="/items">
+		<item name="knife"><property name="Weight" value="2"/></item>
+	</append>
+</configs>`)
+	balance := mustXml(t, `<configs>
+
+	<set xpath="//property[@name='EntityDamage']/@value">40</set>
+	<remove xpath="//item[@name='gunPistol']/property[@name='Weight']"/>
+	<removeattribute xpath="//item[@name='gunPistol']/@tags"/>
+</configs>`)
+	results, err := doc.ApplyPatchEnv(core, PatchEnv{Mod: "Core", Patch: "core.xml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Line != 2 || results[1].Line != 3 {
+		t.Errorf("lines = %d, %d; want 2, 3", results[0].Line, results[1].Line)
+	}
+	if _, err := doc.ApplyPatchEnv(balance, PatchEnv{Mod: "Balance", Patch: "balance.xml"}); err != nil {
+		t.Fatal(err)
+	}
+
+	blame, err := doc.Blame("//property[@name='EntityDamage']/@value")
+	if err != nil {
+		t.Fatalf("Blame: %v", err)
+	}
+	want := []Blame{{
+		Path:  "/items/item[@name='gunPistol']/property[@name='EntityDamage']/@value",
+		Value: "40",
+		Origins: []Origin{
+			{Mod: "Core", Patch: "core.xml", Line: 2, Op: OpSet},
+			{Mod: "Balance", Patch: "balance.xml", Line: 3, Op: OpSet},
+		},
+	}}
+	if !reflect.DeepEqual(blame, want) {
+		t.Errorf("Blame = %+v, want %+v", blame, want)
+	}
+
+	blame, err = doc.Blame("/items/item")
+	if err != nil {
+		t.Fatalf("Blame: %v", err)
+	}
+	if len(blame) != 2 {
+		t.Fatalf("Blame(/items/item) returned %d nodes, want 2", len(blame))
+	}
+	pistol := []Origin{{Mod: "Balance", Patch: "balance.xml", Line: 4, Op: OpRemove}}
+	tags := []Origin{{Mod: "Balance", Patch: "balance.xml", Line: 5, Op: OpRemoveAttribute}}
+	if !reflect.DeepEqual(blame[0].Origins, pistol) || !reflect.DeepEqual(blame[0].Attrs["tags"], tags) {
+		t.Errorf("gunPistol blame = %+v", blame[0])
+	}
+	knife := []Origin{{Mod: "Core", Patch: "core.xml", Line: 3, Op: OpAppend}}
+	if blame[1].Path != "/items/item[@name='knife']" || !reflect.DeepEqual(blame[1].Origins, knife) {
+		t.Errorf("knife blame = %+v", blame[1])
+	}
+
+	doc.SetOptions(Options{Provenance: true})
+	var x, k bytes.Buffer
+	if err := doc.ToXml(&x); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.ToKdl(&k); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<!-- Balance balance.xml:4 remove; @tags: Balance balance.xml:5 removeattribute -->`,
+		`<!-- @value: Core core.xml:2 set, Balance balance.xml:3 set -->`,
+		`<!-- Core core.xml:3 append -->`,
+	} {
+		if !strings.Contains(x.String(), want) {
+			t.Errorf("xml lacks %s:\n%s", want, x.String())
+		}
+	}
+	for _, want := range []string{
+		`("Balance balance.xml:4 remove; @tags: Balance balance.xml:5 removeattribute")item name="gunPistol"`,
+		`property name="EntityDamage" value=("Core core.xml:2 set, Balance balance.xml:3 set")"40"`,
+		`("Core core.xml:3 append")property name="Weight" value="2"`,
+	} {
+		if !strings.Contains(k.String(), want) {
+			t.Errorf("kdl lacks %s:\n%s", want, k.String())
+		}
+	}
+	if _, err := NewFromKdl(&k); err != nil {
+		t.Errorf("annotated KDL does not parse: %v", err)
+	}
+}
+
+func TestTouched(t *testing.T) {
+	doc := mustXml(t, `<items><item name="a" v="1"/><item name="b" v="1"/><item name="c"/></items>`)
+	for _, p := range []struct{ mod, patch string }{
+		{"Core", `<configs><set xpath="//item/@v">2</set></configs>`},
+		{"Balance", `<configs><remove xpath="//item[@name='a']"/><remove xpath="//item[@name='c']"/></configs>`},
+	} {
+		if _, err := doc.ApplyPatchEnv(mustXml(t, p.patch), PatchEnv{Mod: p.mod}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	set := []Origin{{Mod: "Core", Line: 1, Op: OpSet}}
+	remove := Origin{Mod: "Balance", Line: 1, Op: OpRemove}
+	want := []Blame{
+		{Path: "/items", Origins: []Origin{remove, remove}},
+		{Path: "/items/item[@name='b']", Attrs: map[string][]Origin{"v": set}},
+		{Path: "/items/item[@name='a']", Origins: []Origin{remove}, Attrs: map[string][]Origin{"v": set}, Removed: true},
+		// Removed without any earlier change.
+		{Path: "/items/item[@name='c']", Origins: []Origin{remove}, Removed: true},
+	}
+	if got := doc.Touched(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Touched = %+v, want %+v", got, want)
+	}
+
+	for _, tc := range []struct {
+		xpath string
+		want  []Blame
+	}{
+		{"/items/item[@name='c']", []Blame{{Path: "/items/item[@name='c']", Origins: []Origin{remove}, Removed: true}}},
+		{"//item[@name='a']/@v", []Blame{{Path: "/items/item[@name='a']/@v", Value: "2", Origins: append(set, remove), Removed: true}}},
+		{"/items/item[@v='2']", []Blame{
+			{Path: "/items/item[@name='b']", Attrs: map[string][]Origin{"v": set}},
+			{Path: "/items/item[@name='a']", Origins: []Origin{remove}, Attrs: map[string][]Origin{"v": set}, Removed: true},
+		}},
+	} {
+		blame, err := doc.Blame(tc.xpath)
+		if err != nil {
+			t.Fatalf("Blame(%s): %v", tc.xpath, err)
+		}
+		if !reflect.DeepEqual(blame, tc.want) {
+			t.Errorf("Blame(%s) = %+v, want %+v", tc.xpath, blame, tc.want)
+		}
+	}
+}
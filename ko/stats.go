@@ -0,0 +1,37 @@
+package ko
+
+import "github.com/sblinch/kdl-go/document"
+
+// Stats counts what a document holds.
+type Stats struct {
+	Elements   int `json:"elements"`
+	Attributes int `json:"attributes"`
+	Text       int `json:"text"`
+	Comments   int `json:"comments"`
+}
+
+// Stats walks the document and counts its nodes by kind.
+func (e *Ko) Stats() Stats {
+	var s Stats
+	countNodes(e.doc.Nodes, &s)
+	return s
+}
+
+func countNodes(nodes []*document.Node, s *Stats) {
+	for _, n := range nodes {
+		switch n.Name.NodeNameString() {
+		case "_charset":
+		case commentNodeIdentifier:
+			s.Comments++
+		case textNodeIdentifier:
+			s.Text++
+		default:
+			s.Elements++
+			s.Attributes += len(n.Properties)
+			if len(n.Arguments) > 0 {
+				s.Text++
+			}
+		}
+		countNodes(n.Children, s)
+	}
+}
@@ -0,0 +1,1079 @@
+package ko
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/sblinch/kdl-go/document"
+)
+
+// This file evaluates the subset of XPath 1.0 that modlets use: location
+// paths with the child, descendant, parent, ancestor, sibling, self and
+// attribute axes (and their abbreviations), name, *, text() and node()
+// tests, predicates, filter expressions such as (//item)[1], the or, and, =,
+// !=, <, <=, >, >=, +, -, *, div, mod and | operators, and the functions not,
+// boolean, contains, starts-with, string, number, normalize-space,
+// string-length, count, position, last, name, local-name, true and false.
+// Node-sets are in document order. Not supported: variables, the following,
+// preceding and namespace axes, comment() and processing-instruction()
+// tests, and the other functions. Comments are invisible to it, as the game
+// strips them.
+
+// xpath is a compiled XPath expression.
+type xpath struct {
+	src  string
+	expr xexpr
+}
+
+func compileXPath(src string) (*xpath, error) {
+	p := &xparser{src: src}
+	err := p.tokenize()
+	if err != nil {
+		return nil, fmt.Errorf("xpath %q: %w", src, err)
+	}
+	expr, err := p.parseExpr()
+	if err == nil && p.pos < len(p.toks) {
+		err = fmt.Errorf("unexpected %q", p.toks[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("xpath %q: %w", src, err)
+	}
+	return &xpath{src: src, expr: expr}, nil
+}
+
+// Match returns the number of nodes xpath selects in the document.
+func (e *Ko) Match(xpath string) (int, error) {
+	x, err := compileXPath(xpath)
+	if err != nil {
+		return 0, err
+	}
+	nodes, err := newXTree(e.doc.Nodes).selectNodes(x)
+	return len(nodes), err
+}
+
+// namesOne reports whether x has the shape of a path to a single node: an
+// absolute path of named child steps, each below the root element selected
+// by key, such as [@name='gunPistol'], or by position, optionally ending in
+// a named attribute.
+func (x *xpath) namesOne() bool {
+	loc, ok := x.expr.(xlocation)
+	if !ok || !loc.absolute || len(loc.steps) == 0 {
+		return false
+	}
+	for i, s := range loc.steps {
+		switch {
+		case s.test == "*" || s.test == "node()" || s.test == "text()":
+			return false
+		case s.axis == "attribute":
+			if i != len(loc.steps)-1 || len(s.preds) > 0 {
+				return false
+			}
+		case s.axis != "child":
+			return false
+		case i > 0 && len(s.preds) == 0:
+			return false
+		}
+		for _, pred := range s.preds {
+			if !keyPredicate(pred) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// keyPredicate reports whether e picks an element by key: a position, or an
+// attribute compared to a literal, alone or joined to other tests by and.
+func keyPredicate(e xexpr) bool {
+	switch e := e.(type) {
+	case xnumber:
+		return true
+	case xbinary:
+		switch e.op {
+		case "and":
+			return keyPredicate(e.l) || keyPredicate(e.r)
+		case "=":
+			return isAttrRef(e.l) && isLiteral(e.r) || isAttrRef(e.r) && isLiteral(e.l)
+		}
+	}
+	return false
+}
+
+func isAttrRef(e xexpr) bool {
+	loc, ok := e.(xlocation)
+	return ok && !loc.absolute && len(loc.steps) == 1 && loc.steps[0].axis == "attribute" && loc.steps[0].test != "*"
+}
+
+func isLiteral(e xexpr) bool {
+	_, ok := e.(xliteral)
+	return ok
+}
+
+type xexpr interface{}
+
+type (
+	xlocation struct {
+		absolute bool
+		steps    []xstep
+	}
+	xstep struct {
+		axis  string
+		test  string // a name, "*", "text()" or "node()"
+		preds []xexpr
+	}
+	xbinary struct {
+		op   string
+		l, r xexpr
+	}
+	xcall struct {
+		name string
+		args []xexpr
+	}
+	// xfilter is a filter expression: the node-set expr selects, filtered
+	// by preds, then followed by steps.
+	xfilter struct {
+		expr  xexpr
+		preds []xexpr
+		steps []xstep
+	}
+	xliteral string
+	xnumber  float64
+)
+
+type xtoken struct {
+	kind string // "op", "name", "literal", "number", "axis"
+	text string
+}
+
+type xparser struct {
+	src  string
+	toks []xtoken
+	pos  int
+}
+
+func (p *xparser) tokenize() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return fmt.Errorf("unterminated string at %d", i+1)
+			}
+			p.toks = append(p.toks, xtoken{"literal", s[i+1 : i+1+end]})
+			i += end + 2
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			j := i
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.') {
+				j++
+			}
+			p.toks = append(p.toks, xtoken{"number", s[i:j]})
+			i = j
+		case strings.HasPrefix(s[i:], "//"), strings.HasPrefix(s[i:], ".."),
+			strings.HasPrefix(s[i:], "!="), strings.HasPrefix(s[i:], "<="), strings.HasPrefix(s[i:], ">="):
+			p.toks = append(p.toks, xtoken{"op", s[i : i+2]})
+			i += 2
+		case strings.ContainsRune("/[]()@,|.*=<>+-", rune(c)):
+			p.toks = append(p.toks, xtoken{"op", s[i : i+1]})
+			i++
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(s) && (s[j] == '_' || s[j] == '-' || s[j] == '.' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) ||
+				s[j] == ':' && !strings.HasPrefix(s[j:], "::")) {
+				j++
+			}
+			if strings.HasPrefix(s[j:], "::") {
+				p.toks = append(p.toks, xtoken{"axis", s[i:j]})
+				j += 2
+			} else {
+				p.toks = append(p.toks, xtoken{"name", s[i:j]})
+			}
+			i = j
+		default:
+			return fmt.Errorf("unexpected %q at %d", c, i+1)
+		}
+	}
+	return nil
+}
+
+func (p *xparser) peek() xtoken {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return xtoken{}
+}
+
+func (p *xparser) isOp(text string) bool {
+	t := p.peek()
+	return t.kind == "op" && t.text == text
+}
+
+func (p *xparser) expect(text string) error {
+	if !p.isOp(text) {
+		if p.pos >= len(p.toks) {
+			return fmt.Errorf("expected %q at end", text)
+		}
+		return fmt.Errorf("expected %q, found %q", text, p.peek().text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *xparser) parseExpr() (xexpr, error) {
+	return p.parseBinary(0)
+}
+
+// xprecedence lists the binary operators from loosest to tightest. Unary
+// minus binds tighter than * and looser than |.
+var xprecedence = [][]string{{"or"}, {"and"}, {"=", "!="}, {"<", "<=", ">", ">="}, {"+", "-"}, {"*", "div", "mod"}, {"|"}}
+
+func (p *xparser) parseBinary(level int) (xexpr, error) {
+	if level == len(xprecedence) {
+		return p.parseUnary()
+	}
+	if xprecedence[level][0] == "|" && p.isOp("-") {
+		p.pos++
+		e, err := p.parseBinary(level)
+		if err != nil {
+			return nil, err
+		}
+		return xbinary{op: "-", l: xnumber(0), r: e}, nil
+	}
+	l, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		found := ""
+		for _, op := range xprecedence[level] {
+			if (t.kind == "op" || t.kind == "name") && t.text == op {
+				found = op
+			}
+		}
+		if found == "" {
+			return l, nil
+		}
+		p.pos++
+		r, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = xbinary{op: found, l: l, r: r}
+	}
+}
+
+func (p *xparser) parseUnary() (xexpr, error) {
+	t := p.peek()
+	switch {
+	case t.kind == "literal":
+		p.pos++
+		return xliteral(t.text), nil
+	case t.kind == "number":
+		p.pos++
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", t.text)
+		}
+		return xnumber(f), nil
+	case p.isOp("("):
+		p.pos++
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return p.parseFilter(e)
+	case t.kind == "name" && p.pos+1 < len(p.toks) && p.toks[p.pos+1].text == "(" &&
+		t.text != "text" && t.text != "node":
+		p.pos += 2
+		call := xcall{name: t.text}
+		for !p.isOp(")") {
+			if len(call.args) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+		}
+		p.pos++
+		return call, nil
+	case t.kind == "":
+		return nil, fmt.Errorf("unexpected end")
+	default:
+		return p.parseLocation()
+	}
+}
+
+// parseFilter parses the predicates and path that may follow a
+// parenthesized expression, as in (//item)[1]/@name.
+func (p *xparser) parseFilter(e xexpr) (xexpr, error) {
+	f := xfilter{expr: e}
+	for p.isOp("[") {
+		p.pos++
+		pred, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		f.preds = append(f.preds, pred)
+	}
+	if p.isOp("/") || p.isOp("//") {
+		if p.isOp("//") {
+			f.steps = append(f.steps, xstep{axis: "descendant-or-self", test: "node()"})
+		}
+		p.pos++
+		loc, err := p.parseLocation()
+		if err != nil {
+			return nil, err
+		}
+		f.steps = append(f.steps, loc.(xlocation).steps...)
+	}
+	if len(f.preds) == 0 && len(f.steps) == 0 {
+		return e, nil
+	}
+	return f, nil
+}
+
+func (p *xparser) parseLocation() (xexpr, error) {
+	loc := xlocation{}
+	switch {
+	case p.isOp("/"):
+		p.pos++
+		loc.absolute = true
+		if !p.startsStep() {
+			return loc, nil // "/" alone selects the document
+		}
+	case p.isOp("//"):
+		p.pos++
+		loc.absolute = true
+		loc.steps = append(loc.steps, xstep{axis: "descendant-or-self", test: "node()"})
+	}
+	for {
+		step, err := p.parseStep()
+		if err != nil {
+			return nil, err
+		}
+		loc.steps = append(loc.steps, step)
+		switch {
+		case p.isOp("/"):
+			p.pos++
+		case p.isOp("//"):
+			p.pos++
+			loc.steps = append(loc.steps, xstep{axis: "descendant-or-self", test: "node()"})
+		default:
+			return loc, nil
+		}
+	}
+}
+
+func (p *xparser) startsStep() bool {
+	t := p.peek()
+	return t.kind == "name" || t.kind == "axis" || t.kind == "op" && (t.text == "@" || t.text == "*" || t.text == "." || t.text == "..")
+}
+
+var xaxes = map[string]bool{
+	"child": true, "descendant": true, "descendant-or-self": true, "parent": true,
+	"ancestor": true, "ancestor-or-self": true, "following-sibling": true,
+	"preceding-sibling": true, "self": true, "attribute": true,
+}
+
+func (p *xparser) parseStep() (xstep, error) {
+	switch {
+	case p.isOp("."):
+		p.pos++
+		return xstep{axis: "self", test: "node()"}, nil
+	case p.isOp(".."):
+		p.pos++
+		return xstep{axis: "parent", test: "node()"}, nil
+	}
+	step := xstep{axis: "child"}
+	t := p.peek()
+	switch {
+	case p.isOp("@"):
+		p.pos++
+		step.axis = "attribute"
+	case t.kind == "axis":
+		if !xaxes[t.text] {
+			return step, fmt.Errorf("unsupported axis %q", t.text)
+		}
+		p.pos++
+		step.axis = t.text
+	}
+
+	t = p.peek()
+	switch {
+	case p.isOp("*"):
+		p.pos++
+		step.test = "*"
+	case t.kind == "name" && (t.text == "text" || t.text == "node") && p.pos+1 < len(p.toks) && p.toks[p.pos+1].text == "(":
+		p.pos += 2
+		if err := p.expect(")"); err != nil {
+			return step, err
+		}
+		step.test = t.text + "()"
+	case t.kind == "name":
+		p.pos++
+		step.test = t.text
+	default:
+		if t.kind == "" {
+			return step, fmt.Errorf("expected a step at end")
+		}
+		return step, fmt.Errorf("expected a step, found %q", t.text)
+	}
+
+	for p.isOp("[") {
+		p.pos++
+		pred, err := p.parseExpr()
+		if err != nil {
+			return step, err
+		}
+		if err := p.expect("]"); err != nil {
+			return step, err
+		}
+		step.preds = append(step.preds, pred)
+	}
+	return step, nil
+}
+
+// xnode is an element, a text node, or with attr set an attribute of the
+// element n.
+type xnode struct {
+	n    *document.Node
+	attr string
+}
+
+// xtree evaluates XPath over a document. Its root stands for the document
+// itself, whose children are the document's top-level nodes.
+type xtree struct {
+	root   *document.Node
+	parent map[*document.Node]*document.Node
+}
+
+func newXTree(nodes []*document.Node) *xtree {
+	t := &xtree{
+		root:   &document.Node{Name: &document.Value{Value: ""}, Children: nodes},
+		parent: make(map[*document.Node]*document.Node),
+	}
+	t.adopt(t.root, nodes)
+	return t
+}
+
+// within reports whether n is top or one of its descendants.
+func (t *xtree) within(n, top *document.Node) bool {
+	for ; n != nil; n = t.parent[n] {
+		if n == top {
+			return true
+		}
+	}
+	return false
+}
+
+// adopt records parent as the parent of nodes and their descendants.
+func (t *xtree) adopt(parent *document.Node, nodes []*document.Node) {
+	for _, n := range nodes {
+		t.parent[n] = parent
+		t.adopt(n, n.Children)
+	}
+}
+
+type xcontext struct {
+	node      xnode
+	pos, size int
+}
+
+// selectNodes evaluates x against the document and returns the nodes it
+// selects.
+func (t *xtree) selectNodes(x *xpath) ([]xnode, error) {
+	v, err := t.eval(x.expr, xcontext{node: xnode{n: t.root}, pos: 1, size: 1})
+	if err != nil {
+		return nil, fmt.Errorf("xpath %q: %w", x.src, err)
+	}
+	nodes, ok := v.([]xnode)
+	if !ok {
+		return nil, fmt.Errorf("xpath %q does not select nodes", x.src)
+	}
+	return nodes, nil
+}
+
+func (t *xtree) eval(e xexpr, ctx xcontext) (interface{}, error) {
+	switch e := e.(type) {
+	case xliteral:
+		return string(e), nil
+	case xnumber:
+		return float64(e), nil
+	case xlocation:
+		return t.location(e, ctx)
+	case xbinary:
+		return t.binary(e, ctx)
+	case xcall:
+		return t.call(e, ctx)
+	case xfilter:
+		return t.filter(e, ctx)
+	}
+	return nil, fmt.Errorf("cannot evaluate %T", e)
+}
+
+func (t *xtree) filter(f xfilter, ctx xcontext) ([]xnode, error) {
+	v, err := t.eval(f.expr, ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodes, ok := v.([]xnode)
+	if !ok {
+		return nil, fmt.Errorf("predicates and paths need a node-set")
+	}
+	nodes, err = t.predicates(f.preds, t.docOrder(nodes))
+	if err != nil {
+		return nil, err
+	}
+	return t.steps(f.steps, nodes)
+}
+
+func (t *xtree) location(loc xlocation, ctx xcontext) ([]xnode, error) {
+	nodes := []xnode{ctx.node}
+	if loc.absolute {
+		nodes = []xnode{{n: t.root}}
+	}
+	return t.steps(loc.steps, nodes)
+}
+
+// steps applies steps in turn, starting from nodes.
+func (t *xtree) steps(steps []xstep, nodes []xnode) ([]xnode, error) {
+	for _, step := range steps {
+		var out []xnode
+		seen := make(map[xnode]bool)
+		for _, n := range nodes {
+			matched, err := t.step(step, n)
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range matched {
+				if !seen[m] {
+					seen[m] = true
+					out = append(out, m)
+				}
+			}
+		}
+		switch step.axis {
+		case "child", "attribute", "self":
+			// Children of nodes in document order are in document order.
+		case "descendant", "descendant-or-self", "following-sibling":
+			// In order from one node, but they can interleave from several.
+			if len(nodes) > 1 {
+				out = t.docOrder(out)
+			}
+		default:
+			// Reverse axes yield the nearest node first.
+			out = t.docOrder(out)
+		}
+		nodes = out
+	}
+	return nodes, nil
+}
+
+// docOrder sorts nodes into document order: each element before its
+// attributes, in name order, and its attributes before its children.
+func (t *xtree) docOrder(nodes []xnode) []xnode {
+	if len(nodes) < 2 {
+		return nodes
+	}
+	index := make(map[*document.Node]int)
+	indexed := make(map[*document.Node]bool)
+	keys := make(map[xnode][]int, len(nodes))
+	for _, n := range nodes {
+		var key []int
+		for c := n.n; c != t.root; {
+			p := t.parent[c]
+			if p == nil {
+				break
+			}
+			if !indexed[p] {
+				for i, sib := range p.Children {
+					index[sib] = i
+				}
+				indexed[p] = true
+			}
+			key = append(key, index[c])
+			c = p
+		}
+		for i, j := 0, len(key)-1; i < j; i, j = i+1, j-1 {
+			key[i], key[j] = key[j], key[i]
+		}
+		if n.attr != "" {
+			key = append(key, -1) // before the children
+		}
+		keys[n] = key
+	}
+	sorted := append([]xnode(nil), nodes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := keys[sorted[i]], keys[sorted[j]]
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return sorted[i].attr < sorted[j].attr
+	})
+	return sorted
+}
+
+// step returns the nodes step selects from n, filtered by its predicates.
+func (t *xtree) step(step xstep, n xnode) ([]xnode, error) {
+	var cands []xnode
+	if n.attr != "" {
+		// Attributes have no children or attributes of their own.
+		switch step.axis {
+		case "self", "ancestor-or-self", "descendant-or-self":
+			cands = append(cands, n)
+		}
+		if step.axis == "parent" || step.axis == "ancestor" || step.axis == "ancestor-or-self" {
+			cands = append(cands, xnode{n: n.n})
+			if step.axis != "parent" {
+				cands = append(cands, t.ancestors(n.n)...)
+			}
+		}
+	} else {
+		switch step.axis {
+		case "child":
+			cands = t.children(n.n)
+		case "descendant":
+			cands = t.descendants(n.n, nil)
+		case "descendant-or-self":
+			cands = t.descendants(n.n, []xnode{n})
+		case "self":
+			cands = []xnode{n}
+		case "parent":
+			if p := t.parent[n.n]; p != nil {
+				cands = []xnode{{n: p}}
+			}
+		case "ancestor":
+			cands = t.ancestors(n.n)
+		case "ancestor-or-self":
+			cands = append([]xnode{n}, t.ancestors(n.n)...)
+		case "following-sibling", "preceding-sibling":
+			sibs := t.children(t.parent[n.n])
+			for i, s := range sibs {
+				if s.n != n.n {
+					continue
+				}
+				if step.axis == "following-sibling" {
+					cands = sibs[i+1:]
+				} else {
+					for j := i - 1; j >= 0; j-- {
+						cands = append(cands, sibs[j])
+					}
+				}
+				break
+			}
+		case "attribute":
+			keys := make([]string, 0, len(n.n.Properties))
+			for k := range n.n.Properties {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				cands = append(cands, xnode{n: n.n, attr: k})
+			}
+		}
+	}
+
+	var out []xnode
+	for _, c := range cands {
+		if t.matches(step, c) {
+			out = append(out, c)
+		}
+	}
+	return t.predicates(step.preds, out)
+}
+
+// predicates filters nodes, in the order of their axis, by each of preds in
+// turn.
+func (t *xtree) predicates(preds []xexpr, out []xnode) ([]xnode, error) {
+	for _, pred := range preds {
+		var kept []xnode
+		for i, c := range out {
+			v, err := t.eval(pred, xcontext{node: c, pos: i + 1, size: len(out)})
+			if err != nil {
+				return nil, err
+			}
+			if f, ok := v.(float64); ok {
+				if f == float64(i+1) {
+					kept = append(kept, c)
+				}
+			} else if xboolean(v) {
+				kept = append(kept, c)
+			}
+		}
+		out = kept
+	}
+	return out, nil
+}
+
+func (t *xtree) matches(step xstep, n xnode) bool {
+	if n.attr != "" {
+		return step.axis == "attribute" && (step.test == "*" || step.test == "node()" || step.test == n.attr) ||
+			step.axis != "attribute" && step.test == "node()"
+	}
+	if n.n == t.root {
+		return step.test == "node()"
+	}
+	name := n.n.Name.NodeNameString()
+	switch step.test {
+	case "node()":
+		return true
+	case "text()":
+		return name == textNodeIdentifier
+	case "*":
+		return name != textNodeIdentifier
+	default:
+		return name == step.test
+	}
+}
+
+// children returns the elements and text nodes under n.
+func (t *xtree) children(n *document.Node) []xnode {
+	if n == nil {
+		return nil
+	}
+	var out []xnode
+	for _, c := range n.Children {
+		name := c.Name.NodeNameString()
+		if name == commentNodeIdentifier || name == "_charset" {
+			continue
+		}
+		out = append(out, xnode{n: c})
+	}
+	return out
+}
+
+func (t *xtree) descendants(n *document.Node, out []xnode) []xnode {
+	for _, c := range t.children(n) {
+		out = append(out, c)
+		out = t.descendants(c.n, out)
+	}
+	return out
+}
+
+// ancestors returns the ancestors of n, nearest first, ending with the
+// document.
+func (t *xtree) ancestors(n *document.Node) []xnode {
+	var out []xnode
+	for p := t.parent[n]; p != nil; p = t.parent[p] {
+		out = append(out, xnode{n: p})
+	}
+	return out
+}
+
+// stringValue returns the XPath string-value of n.
+func (t *xtree) stringValue(n xnode) string {
+	if n.attr != "" {
+		if v, ok := n.n.Properties[n.attr]; ok {
+			return v.ValueString()
+		}
+		return ""
+	}
+	var b strings.Builder
+	var walk func(n *document.Node)
+	walk = func(n *document.Node) {
+		if n.Name.NodeNameString() == textNodeIdentifier {
+			for _, a := range n.Arguments {
+				b.WriteString(a.ValueString())
+			}
+			return
+		}
+		for _, a := range n.Arguments {
+			b.WriteString(a.ValueString())
+		}
+		for _, c := range t.children(n) {
+			walk(c.n)
+		}
+	}
+	walk(n.n)
+	return b.String()
+}
+
+func (t *xtree) binary(e xbinary, ctx xcontext) (interface{}, error) {
+	l, err := t.eval(e.l, ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "or":
+		if xboolean(l) {
+			return true, nil
+		}
+	case "and":
+		if !xboolean(l) {
+			return false, nil
+		}
+	}
+	r, err := t.eval(e.r, ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "or", "and":
+		return xboolean(r), nil
+	case "|":
+		ln, lok := l.([]xnode)
+		rn, rok := r.([]xnode)
+		if !lok || !rok {
+			return nil, fmt.Errorf("| needs node-sets")
+		}
+		union := append([]xnode{}, ln...)
+		seen := make(map[xnode]bool, len(ln))
+		for _, n := range ln {
+			seen[n] = true
+		}
+		for _, n := range rn {
+			if !seen[n] {
+				union = append(union, n)
+			}
+		}
+		return t.docOrder(union), nil
+	case "+", "-", "*", "div", "mod":
+		a, b := xnumberOf(t.atom(l)), xnumberOf(t.atom(r))
+		switch e.op {
+		case "+":
+			return a + b, nil
+		case "-":
+			return a - b, nil
+		case "*":
+			return a * b, nil
+		case "div":
+			return a / b, nil
+		default:
+			return math.Mod(a, b), nil
+		}
+	}
+	return t.compare(e.op, l, r), nil
+}
+
+// atom converts a node-set to the string-value of its first node, and
+// leaves other values alone.
+func (t *xtree) atom(v interface{}) interface{} {
+	nodes, ok := v.([]xnode)
+	if !ok {
+		return v
+	}
+	if len(nodes) == 0 {
+		return ""
+	}
+	return t.stringValue(nodes[0])
+}
+
+// compare applies a comparison operator with XPath 1.0 conversions: a
+// node-set compares true when any of its nodes does.
+func (t *xtree) compare(op string, l, r interface{}) bool {
+	if ln, ok := l.([]xnode); ok {
+		if _, ok := r.(bool); ok {
+			return compareAtoms(op, len(ln) > 0, r)
+		}
+		for _, n := range ln {
+			if t.compare(op, t.stringValue(n), r) {
+				return true
+			}
+		}
+		return false
+	}
+	if rn, ok := r.([]xnode); ok {
+		if _, ok := l.(bool); ok {
+			return compareAtoms(op, l, len(rn) > 0)
+		}
+		for _, n := range rn {
+			if t.compare(op, l, t.stringValue(n)) {
+				return true
+			}
+		}
+		return false
+	}
+	return compareAtoms(op, l, r)
+}
+
+func compareAtoms(op string, l, r interface{}) bool {
+	if op == "=" || op == "!=" {
+		var eq bool
+		_, lb := l.(bool)
+		_, rb := r.(bool)
+		_, ln := l.(float64)
+		_, rn := r.(float64)
+		switch {
+		case lb || rb:
+			eq = xboolean(l) == xboolean(r)
+		case ln || rn:
+			eq = xnumberOf(l) == xnumberOf(r)
+		default:
+			eq = xstringOf(l) == xstringOf(r)
+		}
+		return eq == (op == "=")
+	}
+	a, b := xnumberOf(l), xnumberOf(r)
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	default:
+		return a >= b
+	}
+}
+
+func (t *xtree) call(e xcall, ctx xcontext) (interface{}, error) {
+	args := make([]interface{}, len(e.args))
+	for i, a := range e.args {
+		v, err := t.eval(a, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if nodes, ok := v.([]xnode); ok && e.name != "count" {
+			// Functions other than count take the first node's string.
+			s := ""
+			if len(nodes) > 0 {
+				s = t.stringValue(nodes[0])
+			}
+			if e.name == "not" || e.name == "boolean" {
+				args[i] = len(nodes) > 0
+				continue
+			}
+			args[i] = s
+			continue
+		}
+		args[i] = v
+	}
+	argc := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("%s() takes %d arguments, got %d", e.name, n, len(args))
+		}
+		return nil
+	}
+
+	switch e.name {
+	case "not", "boolean":
+		if err := argc(1); err != nil {
+			return nil, err
+		}
+		return xboolean(args[0]) == (e.name == "boolean"), nil
+	case "true", "false":
+		return e.name == "true", argc(0)
+	case "contains", "starts-with":
+		if err := argc(2); err != nil {
+			return nil, err
+		}
+		s, sub := xstringOf(args[0]), xstringOf(args[1])
+		if e.name == "contains" {
+			return strings.Contains(s, sub), nil
+		}
+		return strings.HasPrefix(s, sub), nil
+	case "string", "normalize-space", "string-length", "number":
+		var v interface{} = t.stringValue(ctx.node)
+		if len(args) == 1 {
+			v = args[0]
+		} else if len(args) > 1 {
+			return nil, fmt.Errorf("%s() takes at most 1 argument", e.name)
+		}
+		switch e.name {
+		case "string":
+			return xstringOf(v), nil
+		case "normalize-space":
+			return strings.Join(strings.Fields(xstringOf(v)), " "), nil
+		case "string-length":
+			return float64(len([]rune(xstringOf(v)))), nil
+		default:
+			return xnumberOf(v), nil
+		}
+	case "count":
+		if err := argc(1); err != nil {
+			return nil, err
+		}
+		nodes, ok := args[0].([]xnode)
+		if !ok {
+			return nil, fmt.Errorf("count() needs a node-set")
+		}
+		return float64(len(nodes)), nil
+	case "position":
+		return float64(ctx.pos), argc(0)
+	case "last":
+		return float64(ctx.size), argc(0)
+	case "name", "local-name":
+		if err := argc(0); err != nil {
+			return nil, err
+		}
+		if ctx.node.attr != "" {
+			return ctx.node.attr, nil
+		}
+		if ctx.node.n == t.root {
+			return "", nil
+		}
+		return ctx.node.n.Name.NodeNameString(), nil
+	}
+	return nil, fmt.Errorf("unsupported function %s()", e.name)
+}
+
+func xboolean(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return v != ""
+	case []xnode:
+		return len(v) > 0
+	}
+	return false
+}
+
+func xnumberOf(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return math.NaN()
+		}
+		return f
+	}
+	return math.NaN()
+}
+
+func xstringOf(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return ""
+}
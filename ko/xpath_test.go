@@ -0,0 +1,81 @@
+package ko
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestXPath(t *testing.T) {
+	k := mustXml(t, `<items>
+	<item name="gunPistol" tags="gun,pistol">
+		<property name="EntityDamage" value="30"/>
+		<property class="Action0"><property name="Delay" value=".2"/></property>
+	</item>
+	<item name="gunRifle" tags="gun">
+		<property name="EntityDamage" value="45"/>
+	</item>
+	<item name="knife"><description>sharp</description></item>
+</items>`)
+	tree := newXTree(k.doc.Nodes)
+
+	tests := []struct {
+		xpath string
+		want  []string
+	}{
+		{"/items/item[@name='gunPistol']/property[@name='EntityDamage']/@value", []string{"30"}},
+		{"//property[@name='EntityDamage']/@value", []string{"30", "45"}},
+		{"/items/item[contains(@tags, 'pistol')]/@name", []string{"gunPistol"}},
+		{"/items/item[starts-with(@name, 'gun') and not(contains(@tags, 'pistol'))]/@name", []string{"gunRifle"}},
+		{"/items/item[property[@name='EntityDamage']/@value > 40]/@name", []string{"gunRifle"}},
+		{"/items/item[2]/@name", []string{"gunRifle"}},
+		{"/items/item[last()]/@name", []string{"knife"}},
+		{"/items/item[@name='gunPistol' or @name='knife']/@name", []string{"gunPistol", "knife"}},
+		{"//property[@name='Delay']/../@class", []string{"Action0"}},
+		{"//property[@name='Delay']/ancestor::item/@name", []string{"gunPistol"}},
+		{"/items/item[description='sharp']/@name", []string{"knife"}},
+		{"/items/item[@name=\"knife\"]/description/text()", []string{"sharp"}},
+		{"/items/item[count(property) = 1]/@name", []string{"gunRifle"}},
+		{"/items/item[@name='gunRifle']/preceding-sibling::item/@name", []string{"gunPistol"}},
+		{"/items/*[@tags]/@name", []string{"gunPistol", "gunRifle"}},
+		{"/items/item[@name='missing']", nil},
+		{"/items/item[position()=last()-1]/@name", []string{"gunRifle"}},
+		{"/items/item[position() mod 2 = 1]/@name", []string{"gunPistol", "knife"}},
+		{"/items/item[property[@name='EntityDamage']/@value div 3 = 15]/@name", []string{"gunRifle"}},
+		{"/items/item[-(-2)]/@name", []string{"gunRifle"}},
+		{"/items/item[2 * 1 + 1]/@name", []string{"knife"}},
+		{"(//item)[1]/@name", []string{"gunPistol"}},
+		{"(//property[@name='EntityDamage'])[last()]/@value", []string{"45"}},
+		{"//item[@name='knife']/@name | //item[@name='gunPistol']/@name | //item[@name='knife']/@name", []string{"gunPistol", "knife"}},
+		{"/items/item[@name='knife']/preceding-sibling::item/@name", []string{"gunPistol", "gunRifle"}},
+	}
+	for _, tt := range tests {
+		x, err := compileXPath(tt.xpath)
+		if err != nil {
+			t.Errorf("compileXPath(%s): %v", tt.xpath, err)
+			continue
+		}
+		nodes, err := tree.selectNodes(x)
+		if err != nil {
+			t.Errorf("selectNodes(%s): %v", tt.xpath, err)
+			continue
+		}
+		var got []string
+		for _, n := range nodes {
+			got = append(got, tree.stringValue(n))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %q, want %q", tt.xpath, got, tt.want)
+		}
+		if n, err := k.Match(tt.xpath); err != nil || n != len(tt.want) {
+			t.Errorf("Match(%s) = %d, %v; want %d", tt.xpath, n, err, len(tt.want))
+		}
+	}
+}
+
+func TestXPathErrors(t *testing.T) {
+	for _, bad := range []string{"", "/items/item[", "/items/item[@name='x]", "/items/frob::item", "/items/item[@name=]", "(//item", "/items/item[1 +]"} {
+		if _, err := compileXPath(bad); err == nil {
+			t.Errorf("compileXPath(%q) succeeded", bad)
+		}
+	}
+}
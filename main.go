@@ -1,17 +1,27 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
-	"time"
-
-	"github.com/7daystosettle/data-tool/ko"
 )
 
+// errUsage is returned when the command line could not be understood; the
+// usage text has already been printed by the time it is returned.
+var errUsage = errors.New("invalid usage")
+
+// verdictErrors are results rather than failures: the command has already
+// explained them and only the exit status is left to set.
+var verdictErrors = []error{errVerifyFailed, errNotEqual, errConflicts, errCheckFailed}
+
 func main() {
 	err := run()
 	if err != nil {
+		for _, v := range verdictErrors {
+			if errors.Is(err, v) {
+				os.Exit(1)
+			}
+		}
 		fmt.Printf("Failed to run: %v\n", err)
 		os.Exit(1)
 	}
@@ -19,108 +29,83 @@ func main() {
 }
 
 func run() error {
-	start := time.Now()
-	if len(os.Args) < 3 {
-		fmt.Printf("usage: %s <src_path> <out_path>\n", os.Args[0])
-		os.Exit(1)
+	if len(os.Args) < 2 {
+		printUsage()
+		return errUsage
 	}
-	inPath := os.Args[1]
-	outPath := os.Args[2]
 
-	info, err := os.Stat(inPath)
-	if err != nil {
-		return fmt.Errorf("stat input path: %w", err)
-	}
-
-	if !info.IsDir() {
-		err := convert(inPath, outPath)
-		if err != nil {
-			return fmt.Errorf("convert: %w", err)
-		}
+	switch os.Args[1] {
+	case "convert":
+		return runConvert(os.Args[2:])
+	case "watch":
+		return runWatch(os.Args[2:])
+	case "game":
+		return runGame(os.Args[2:])
+	case "verify":
+		return runVerify(os.Args[2:])
+	case "equal":
+		return runEqual(os.Args[2:])
+	case "diff":
+		return runDiff(os.Args[2:])
+	case "changelog":
+		return runChangelog(os.Args[2:])
+	case "snapshot":
+		return runSnapshot(os.Args[2:])
+	case "history":
+		return runHistory(os.Args[2:])
+	case "merge":
+		return runMerge(os.Args[2:])
+	case "git":
+		return runGit(os.Args[2:])
+	case "modlet":
+		return runModlet(os.Args[2:])
+	case "mods":
+		return runMods(os.Args[2:])
+	case "help", "-h", "--help":
+		printUsage()
 		return nil
-	}
-
-	totalConverted := 0
-
-	files, err := os.ReadDir(inPath)
-	if err != nil {
-		return fmt.Errorf("read input dir: %w", err)
-	}
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-		ext := filepath.Ext(file.Name())
-		if ext != ".xml" && ext != ".kdl" {
-			continue
-		}
-		inFile := filepath.Join(inPath, file.Name())
-		var outFile string
-		if ext == ".xml" {
-			outFile = filepath.Join(outPath, file.Name()[:len(file.Name())-len(ext)]+".kdl")
-		} else {
-			outFile = filepath.Join(outPath, file.Name()[:len(file.Name())-len(ext)]+".xml")
-		}
-		err := convert(inFile, outFile)
-		if err != nil {
-			fmt.Printf("Failed to convert %s: %v\n", inFile, err)
-		}
-
-		totalConverted++
-	}
-
-	fmt.Printf("Converted %d files in %0.2f seconds\n", totalConverted, time.Since(start).Seconds())
-
-	return nil
-}
-
-func convert(inPath, outPath string) error {
-
-	r, err := os.Open(inPath)
-	if err != nil {
-		return fmt.Errorf("open file: %w", err)
-	}
-	defer r.Close()
-
-	var doc *ko.Ko
-
-	inExt := filepath.Ext(inPath)
-	switch inExt {
-	case ".xml":
-		doc, err = ko.NewFromXml(r)
-		if err != nil {
-			return fmt.Errorf("converting xml to kdl: %w", err)
-		}
-
-	case ".kdl":
-		doc, err = ko.NewFromKdl(r)
-		if err != nil {
-			return fmt.Errorf("converting kdl to xml: %w", err)
-		}
-	default:
-		return fmt.Errorf("unsupported input file extension: %s", inExt)
-	}
-
-	w, err := os.Create(outPath)
-	if err != nil {
-		return fmt.Errorf("create %s: %w", filepath.Base(outPath), err)
-	}
-	defer w.Close()
-
-	switch filepath.Ext(outPath) {
-	case ".xml":
-		err = doc.ToXml(w)
-		if err != nil {
-			return fmt.Errorf("writing xml file: %w", err)
-		}
-	case ".kdl":
-		err = doc.ToKdl(w)
-		if err != nil {
-			return fmt.Errorf("writing kdl file: %w", err)
-		}
 	default:
-		return fmt.Errorf("unsupported output file extension: %s", filepath.Ext(outPath))
+		// data-tool <src_path> <out_path> predates subcommands and is
+		// still used by scripts, so treat it as convert.
+		return runConvert(os.Args[1:])
 	}
+}
 
-	return nil
+func printUsage() {
+	fmt.Fprintf(os.Stderr, `usage: %[1]s <command> [flags] [args]
+
+commands:
+  convert [flags] [src_path|- [out_path|-]]
+      convert a file or directory between XML and KDL; "-" is stdin/stdout
+  watch [flags] [src_path out_path]
+      reconvert source files as they change until interrupted
+  game [--steam dir]
+      list 7 Days to Die installs and the vanilla config directory
+  verify [--allow kinds] [--strict] [--summary] <file|dir>
+      check that XML survives a round trip through KDL
+  equal [--ignore list] [-q] <a> <b>
+      exit 0 when two XML or KDL files hold the same data, 1 otherwise
+  diff [-v] [--exit-code] <old> <new>
+      list entities added, removed or changed between two config versions
+  changelog [--format markdown|html] [--out file] <old-dir> <new-dir>
+      write patch notes for every config file changed between two game builds
+  snapshot import|list|export|diff
+      store game config releases and export or diff them later
+  history [--property name] <file> <entity>
+      show each snapshot in which an entity or one of its properties changed
+  merge [-o file] <base> <ours> <theirs>
+      merge two sets of changes to a config file entity by entity
+  git textconv|diff-driver|install
+      make git diff show config files as KDL and as entity changes
+  modlet generate|apply|check
+      write XPath modlet patches from an edited copy, apply them to vanilla,
+      or check them against a game version
+  mods build|blame|conflicts
+      simulate loading a Mods folder, and find which mod changed what
+
+Paths default to the source and output in datatool.kdl, found in the working
+directory or a parent. Run a command with -h to see its flags.
+
+%[1]s <src_path> <out_path> is shorthand for convert.
+`, os.Args[0])
 }
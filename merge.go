@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/7daystosettle/data-tool/ko"
+	"github.com/7daystosettle/data-tool/project"
+)
+
+// errConflicts is returned by merge when conflicts were left in the output,
+// so the command exits non-zero.
+var errConflicts = errors.New("merge conflicts")
+
+func runMerge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	out := fs.String("o", stdioPath, "file to write the merged document to")
+	to := fs.String("to", "", "output format, xml or kdl (default: from the -o extension, else the format of ours)")
+	quiet := fs.Bool("q", false, "do not list conflicts on stderr")
+	configPath := fs.String("config", "", "project file with extra identity rules (default: "+project.FileName+" in the working directory or a parent)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s merge [flags] <base> <ours> <theirs>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() != 3 {
+		fs.Usage()
+		return errUsage
+	}
+
+	format, err := parseFormat(*to)
+	if err != nil {
+		return fmt.Errorf("--to: %w", err)
+	}
+	if format == "" {
+		format = formatFromPath(*out)
+	}
+	if format == "" {
+		format = formatFromPath(fs.Arg(1))
+	}
+	if format == "" {
+		format = formatKdl
+	}
+
+	cfg, err := loadProject(*configPath)
+	if err != nil {
+		return err
+	}
+	reg := registryFor(cfg)
+	var docs [3]*ko.Ko
+	for i := range docs {
+		docs[i], err = loadFile(fs.Arg(i), reg)
+		if err != nil {
+			return err
+		}
+	}
+
+	merged, conflicts := ko.Merge(docs[0], docs[1], docs[2])
+	write := func(w io.Writer) error {
+		return writeDoc(merged, w, format)
+	}
+	if *out == stdioPath {
+		err = write(os.Stdout)
+	} else {
+		err = writeFileAtomic(*out, false, write)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(conflicts) == 0 {
+		return nil
+	}
+	if !*quiet {
+		for _, c := range conflicts {
+			fmt.Fprintln(os.Stderr, c)
+		}
+		fmt.Fprintf(os.Stderr, "%d conflicts, marked with _conflict nodes\n", len(conflicts))
+	}
+	return errConflicts
+}
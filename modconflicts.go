@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/7daystosettle/data-tool/ko"
+	"github.com/7daystosettle/data-tool/mods"
+)
+
+func runModsConflicts(args []string) error {
+	fs := flag.NewFlagSet("mods conflicts", flag.ContinueOnError)
+	mf := addModsFlags(fs)
+	exitCode := fs.Bool("exit-code", false, "exit 1 when mods conflict")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s mods conflicts [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errUsage
+	}
+	b, err := mf.build()
+	if err != nil {
+		return err
+	}
+
+	conflicts := findModConflicts(b)
+	noOps, err := findModNoOps(b)
+	if err != nil {
+		return err
+	}
+	printModConflicts(os.Stdout, conflicts, noOps)
+	if len(conflicts)+len(noOps) == 0 {
+		fmt.Printf("no conflicts between %d mods\n", len(b.mods))
+		return nil
+	}
+	if *exitCode {
+		return errConflicts
+	}
+	return nil
+}
+
+// modConflict is an element or attribute more than one mod changed. The
+// origins are in load order, so the last one wins.
+type modConflict struct {
+	file    string
+	path    string
+	origins []ko.Origin
+	// removed reports that the last mod removed the element.
+	removed bool
+}
+
+// findModConflicts returns the elements and attributes of the build that
+// more than one mod changed. Removing some of an element's children is not
+// counted as changing it, so mods that each remove different entities of a
+// file do not conflict.
+func findModConflicts(b *modBuild) []modConflict {
+	order := make(map[string]int, len(b.mods))
+	for i, m := range b.mods {
+		order[m.Name] = i
+	}
+	var out []modConflict
+	add := func(file, path string, origins []ko.Origin, removed bool) {
+		seen := make(map[string]bool)
+		for _, o := range origins {
+			seen[o.Mod] = true
+		}
+		if len(seen) < 2 {
+			return
+		}
+		sorted := append([]ko.Origin(nil), origins...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return order[sorted[i].Mod] < order[sorted[j].Mod]
+		})
+		out = append(out, modConflict{file: file, path: path, origins: sorted, removed: removed})
+	}
+
+	for _, name := range b.names {
+		for _, t := range b.files[name].Touched() {
+			attrs := make([]string, 0, len(t.Attrs))
+			for attr := range t.Attrs {
+				attrs = append(attrs, attr)
+			}
+			sort.Strings(attrs)
+
+			if t.Removed {
+				// Every change to a removed element is lost, so any
+				// other mod's change conflicts with the removal.
+				var changes []ko.Origin
+				for _, attr := range attrs {
+					changes = append(changes, t.Attrs[attr]...)
+				}
+				changes = append(changes, t.Origins...)
+				add(name, t.Path, changes, true)
+				continue
+			}
+			var changes []ko.Origin
+			for _, o := range t.Origins {
+				if o.Op != ko.OpRemove {
+					changes = append(changes, o)
+				}
+			}
+			add(name, t.Path, changes, false)
+			for _, attr := range attrs {
+				add(name, t.Path+"/@"+attr, t.Attrs[attr], false)
+			}
+		}
+	}
+	return out
+}
+
+// modNoOp is an operation that matched nothing only because an earlier mod
+// removed or changed its target.
+type modNoOp struct {
+	patch  modPatch
+	result ko.PatchResult
+	by     *mods.Mod
+}
+
+// findModNoOps returns the operations of the build that matched nothing
+// although they match the unpatched configs, each with the mod after whose
+// patches they stop matching, found by replaying the build.
+func findModNoOps(b *modBuild) ([]modNoOp, error) {
+	index := make(map[*mods.Mod]int, len(b.mods))
+	for i, m := range b.mods {
+		index[m] = i
+	}
+	pending := make(map[string][]*modNoOp)
+	for _, p := range b.patches {
+		for _, r := range p.results {
+			if r.Op != ko.OpConditional && r.Matched == 0 {
+				pending[p.file] = append(pending[p.file], &modNoOp{patch: p, result: r})
+			}
+		}
+	}
+
+	var out []modNoOp
+	for _, name := range b.names {
+		if len(pending[name]) == 0 {
+			continue
+		}
+		doc, err := loadFile(b.sources[name], b.reg)
+		if err != nil {
+			return nil, err
+		}
+		var open []*modNoOp
+		for _, n := range pending[name] {
+			matched, err := doc.Match(n.result.XPath)
+			if err != nil {
+				return nil, err
+			}
+			if matched > 0 {
+				open = append(open, n)
+			}
+		}
+		for _, p := range b.patches {
+			if len(open) == 0 {
+				break
+			}
+			if p.file != name {
+				continue
+			}
+			// A patch that failed in the build fails again at the same
+			// operation, leaving the ones before it applied as they were.
+			_, err := b.apply(doc, p.mod, p.path)
+			if err != nil && p.err == nil {
+				return nil, fmt.Errorf("replay %s: %w", p.path, err)
+			}
+			still := open[:0]
+			for _, n := range open {
+				if index[n.patch.mod] <= index[p.mod] {
+					continue // applied already; it matched nothing on its own
+				}
+				matched, err := doc.Match(n.result.XPath)
+				if err != nil {
+					return nil, err
+				}
+				if matched > 0 {
+					still = append(still, n)
+					continue
+				}
+				n.by = p.mod
+				out = append(out, *n)
+			}
+			open = still
+		}
+	}
+	return out, nil
+}
+
+func printModConflicts(w io.Writer, conflicts []modConflict, noOps []modNoOp) {
+	for _, c := range conflicts {
+		if c.removed {
+			fmt.Fprintf(w, "%s: %s (removed)\n", c.file, c.path)
+		} else {
+			fmt.Fprintf(w, "%s: %s\n", c.file, c.path)
+		}
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for i, o := range c.origins {
+			fmt.Fprintf(tw, "    %s\t%s:%d\t%s", o.Mod, o.Patch, o.Line, o.Op)
+			if i == len(c.origins)-1 {
+				fmt.Fprint(tw, "\t(wins)")
+			}
+			fmt.Fprintln(tw)
+		}
+		tw.Flush()
+	}
+	for _, n := range noOps {
+		fmt.Fprintf(w, "%s: %s:%d: %s %s matches nothing after %s\n", n.patch.mod.Name, n.patch.path, n.result.Line, n.result.Op, n.result.XPath, n.by.Name)
+	}
+}
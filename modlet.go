@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/7daystosettle/data-tool/ko"
+	"github.com/7daystosettle/data-tool/project"
+)
+
+func runModlet(args []string) error {
+	if len(args) == 0 {
+		printModletUsage()
+		return errUsage
+	}
+	switch args[0] {
+	case "generate":
+		return runModletGenerate(args[1:])
+	case "apply":
+		return runModletApply(args[1:])
+	case "check":
+		return runModletCheck(args[1:])
+	case "help", "-h", "--help":
+		printModletUsage()
+		return nil
+	default:
+		printModletUsage()
+		return errUsage
+	}
+}
+
+func printModletUsage() {
+	fmt.Fprintf(os.Stderr, `usage: %[1]s modlet <command> [flags] [args]
+
+commands:
+  generate [-o out] <vanilla> <edited>
+      write the XPath patches that turn vanilla config files into edited ones
+  apply [-o out] [--strict] <vanilla> <patch>...
+      apply modlet patches to a vanilla config file as the game would
+  check --against <config_dir|snapshot> <patch|modlet_config_dir>...
+      report patch operations that are invalid or match nothing, or match
+      several nodes where they name one
+
+Run a command with -h to see its flags.
+`, os.Args[0])
+}
+
+func runModletGenerate(args []string) error {
+	fs := flag.NewFlagSet("modlet generate", flag.ContinueOnError)
+	out := fs.String("o", stdioPath, "file to write the modlet to, or the modlet's Config directory when given directories")
+	configPath := fs.String("config", "", "project file with extra identity rules (default: "+project.FileName+" in the working directory or a parent)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s modlet generate [flags] <vanilla> <edited>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errUsage
+	}
+
+	cfg, err := loadProject(*configPath)
+	if err != nil {
+		return err
+	}
+	reg := registryFor(cfg)
+
+	info, err := os.Stat(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("stat edited: %w", err)
+	}
+	if info.IsDir() {
+		if *out == stdioPath {
+			return fmt.Errorf("-o: an output directory is required for directories")
+		}
+		return generateModletDir(fs.Arg(0), fs.Arg(1), *out, reg)
+	}
+
+	modlet, err := generateModlet(fs.Arg(0), fs.Arg(1), reg)
+	if err != nil {
+		return err
+	}
+	write := func(w io.Writer) error {
+		return writeDoc(modlet, w, formatXml)
+	}
+	if *out == stdioPath {
+		return write(os.Stdout)
+	}
+	return writeFileAtomic(*out, false, write)
+}
+
+func generateModlet(vanillaPath, editedPath string, reg *ko.Registry) (*ko.Ko, error) {
+	vanilla, err := loadFile(vanillaPath, reg)
+	if err != nil {
+		return nil, err
+	}
+	edited, err := loadFile(editedPath, reg)
+	if err != nil {
+		return nil, err
+	}
+	modlet, err := ko.GenerateModlet(vanilla, edited)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", editedPath, err)
+	}
+	return modlet, nil
+}
+
+// generateModletDir writes a modlet file into out for each file in edited
+// that differs from the file of the same name in vanilla.
+func generateModletDir(vanillaDir, editedDir, out string, reg *ko.Registry) error {
+	vanilla, err := dirConfigSet(vanillaDir, reg)
+	if err != nil {
+		return err
+	}
+	edited, err := dirConfigSet(editedDir, reg)
+	if err != nil {
+		return err
+	}
+	bases := make([]string, 0, len(edited))
+	for base := range edited {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+
+	err = os.MkdirAll(out, 0o755)
+	if err != nil {
+		return fmt.Errorf("create output dir: %w", err)
+	}
+	written := 0
+	for _, base := range bases {
+		vf, ok := vanilla[base]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "%s: not in %s, skipped\n", edited[base].name, vanillaDir)
+			continue
+		}
+		modlet, err := generateModlet(filepath.Join(vanillaDir, vf.name), filepath.Join(editedDir, edited[base].name), reg)
+		if err != nil {
+			return err
+		}
+		if modlet.Stats().Elements == 1 {
+			continue // only the configs root: no changes
+		}
+		err = writeFileAtomic(filepath.Join(out, base+".xml"), false, func(w io.Writer) error {
+			return writeDoc(modlet, w, formatXml)
+		})
+		if err != nil {
+			return err
+		}
+		written++
+	}
+	fmt.Printf("wrote %d modlet files to %s\n", written, out)
+	return nil
+}
+
+func runModletApply(args []string) error {
+	fs := flag.NewFlagSet("modlet apply", flag.ContinueOnError)
+	out := fs.String("o", stdioPath, "file to write the patched document to")
+	to := fs.String("to", "", "output format, xml or kdl (default: from the -o extension, else xml)")
+	strict := fs.Bool("strict", false, "fail when an operation matches nothing")
+	quiet := fs.Bool("q", false, "do not report operations that match nothing or the branches conditionals take")
+	env := ko.PatchEnv{Mods: make(map[string]string)}
+	fs.Var(modsFlag(env.Mods), "mod", "simulate a loaded mod, as `name[=version]`, for conditional blocks (repeatable)")
+	fs.StringVar(&env.GameVersion, "game-version", "", "game version conditional blocks compare against")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s modlet apply [flags] <vanilla> <patch>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errUsage
+	}
+	format, err := parseFormat(*to)
+	if err != nil {
+		return fmt.Errorf("--to: %w", err)
+	}
+	if format == "" {
+		format = formatFromPath(*out)
+	}
+	if format == "" {
+		format = formatXml
+	}
+
+	doc, err := loadFile(fs.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	unmatched := 0
+	for _, patchPath := range fs.Args()[1:] {
+		patch, err := loadFile(patchPath, nil)
+		if err != nil {
+			return err
+		}
+		results, err := doc.ApplyPatchEnv(patch, env)
+		if err != nil {
+			return fmt.Errorf("%s: %w", patchPath, err)
+		}
+		for _, r := range results {
+			if r.Op == ko.OpConditional {
+				if !*quiet {
+					fmt.Fprintf(os.Stderr, "%s: operation %d: conditional took %s\n", patchPath, r.Index, branchName(r.Branch))
+				}
+				continue
+			}
+			if r.Matched > 0 {
+				continue
+			}
+			unmatched++
+			if !*quiet {
+				fmt.Fprintf(os.Stderr, "%s: operation %d: %s xpath matched nothing: %s\n", patchPath, r.Index, r.Op, r.XPath)
+			}
+		}
+	}
+	if *strict && unmatched > 0 {
+		return fmt.Errorf("%d operations matched nothing", unmatched)
+	}
+
+	write := func(w io.Writer) error {
+		return writeDoc(doc, w, format)
+	}
+	if *out == stdioPath {
+		return write(os.Stdout)
+	}
+	return writeFileAtomic(*out, false, write)
+}
+
+func branchName(branch string) string {
+	switch branch {
+	case "":
+		return "no branch"
+	case "else":
+		return "the else branch"
+	}
+	return fmt.Sprintf("the branch %s", branch)
+}
+
+// modsFlag collects repeated --mod name[=version] flags.
+type modsFlag map[string]string
+
+func (m modsFlag) String() string {
+	var mods []string
+	for name, version := range m {
+		if version != "" {
+			name += "=" + version
+		}
+		mods = append(mods, name)
+	}
+	sort.Strings(mods)
+	return strings.Join(mods, ",")
+}
+
+func (m modsFlag) Set(s string) error {
+	name, version, _ := strings.Cut(s, "=")
+	if name == "" {
+		return errors.New("missing mod name")
+	}
+	m[name] = version
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/7daystosettle/data-tool/ko"
+)
+
+// errCheckFailed is returned by modlet check when a patch has issues, so the
+// command exits non-zero.
+var errCheckFailed = errors.New("patch check failed")
+
+func runModletCheck(args []string) error {
+	fs := flag.NewFlagSet("modlet check", flag.ContinueOnError)
+	sf := addSnapshotFlags(fs)
+	against := fs.String("against", "", "game Config directory or snapshot label to check the patches against (required)")
+	env := ko.PatchEnv{Mods: make(map[string]string)}
+	fs.Var(modsFlag(env.Mods), "mod", "simulate a loaded mod, as `name[=version]`, for conditional blocks (repeatable)")
+	fs.StringVar(&env.GameVersion, "game-version", "", "game version conditional blocks compare against (default: the snapshot label when checking against a snapshot)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s modlet check --against <config_dir|snapshot> [flags] <patch|modlet_config_dir>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() == 0 || *against == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	cfg, store, err := sf.open()
+	if err != nil {
+		return err
+	}
+	reg := registryFor(cfg)
+	var set configSet
+	if info, statErr := os.Stat(*against); statErr == nil && info.IsDir() {
+		set, err = treeConfigSet(*against, reg)
+	} else {
+		set, err = snapshotConfigSet(store, *against, reg)
+		if env.GameVersion == "" {
+			env.GameVersion = *against
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	patches, err := patchFiles(fs.Args())
+	if err != nil {
+		return err
+	}
+	failed, err := checkPatches(os.Stdout, patches, set, *against, env)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d patch files have issues against %s\n", failed, len(patches), *against)
+		return errCheckFailed
+	}
+	fmt.Printf("%d patch files apply cleanly to %s\n", len(patches), *against)
+	return nil
+}
+
+// checkPatches checks each patch against its config file in set, named
+// against, writing the issues to w, and returns how many patches have any.
+// Patch files that do not parse count as invalid; only a config file that
+// cannot be read stops the check.
+func checkPatches(w io.Writer, patches []patchFile, set configSet, against string, env ko.PatchEnv) (int, error) {
+	failed := 0
+	for _, p := range patches {
+		target, ok := set.target(p.name)
+		if !ok {
+			fmt.Fprintf(w, "%s: no config file %s in %s\n", p.path, p.name, against)
+			failed++
+			continue
+		}
+		patch, err := loadFile(p.path, nil)
+		if err != nil {
+			printInvalid(w, p.path, err)
+			failed++
+			continue
+		}
+		doc, err := target.load()
+		if err != nil {
+			return 0, err
+		}
+		issues, err := doc.CheckPatch(patch, env)
+		if err != nil {
+			printInvalid(w, p.path, err)
+			failed++
+			continue
+		}
+		for _, i := range issues {
+			if i.Line > 0 {
+				fmt.Fprintf(w, "%s:%d: %s\n", p.path, i.Line, i)
+			} else {
+				fmt.Fprintf(w, "%s: %s\n", p.path, i)
+			}
+		}
+		if len(issues) > 0 {
+			failed++
+		}
+	}
+	return failed, nil
+}
+
+// printInvalid reports a patch file that cannot be checked at all, at the
+// line of its parse error when it has one.
+func printInvalid(w io.Writer, path string, err error) {
+	var perr *ko.ParseError
+	if errors.As(err, &perr) {
+		err = perr.Err
+		path = fmt.Sprintf("%s:%d", path, perr.Line)
+	}
+	fmt.Fprintf(w, "%s: %s: %v\n", path, ko.IssueInvalid, err)
+}
+
+// patchFile is a modlet patch and the config file it patches, by
+// slash-separated path without extension, such as XUi/windows.
+type patchFile struct {
+	path string
+	name string
+}
+
+// patchFiles expands args, patch files and modlet Config directories, into
+// the patch files to check, in order.
+func patchFiles(args []string) ([]patchFile, error) {
+	var out []patchFile
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, fmt.Errorf("stat patch: %w", err)
+		}
+		if !info.IsDir() {
+			base := filepath.Base(arg)
+			out = append(out, patchFile{path: arg, name: strings.TrimSuffix(base, filepath.Ext(base))})
+			continue
+		}
+		files, err := configTree(arg)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(files))
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			out = append(out, patchFile{path: files[name], name: name})
+		}
+	}
+	return out, nil
+}
+
+// treeConfigSet returns the XML and KDL files under dir, keyed like a
+// snapshot's by slash-separated path without extension.
+func treeConfigSet(dir string, reg *ko.Registry) (configSet, error) {
+	files, err := configTree(dir)
+	if err != nil {
+		return nil, err
+	}
+	set := make(configSet, len(files))
+	for name, p := range files {
+		p := p
+		set[name] = configFile{name: filepath.ToSlash(strings.TrimPrefix(p, dir+string(filepath.Separator))), load: func() (*ko.Ko, error) {
+			return loadFile(p, reg)
+		}}
+	}
+	return set, nil
+}
+
+// target returns the config file a patch named name applies to: the file at
+// the same path, else the only file with the same base name, so a patch
+// given on its own still finds XUi/windows.
+func (s configSet) target(name string) (configFile, bool) {
+	if f, ok := s[name]; ok {
+		return f, true
+	}
+	var found []configFile
+	for key, f := range s {
+		if path.Base(key) == path.Base(name) {
+			found = append(found, f)
+		}
+	}
+	if len(found) != 1 {
+		return configFile{}, false
+	}
+	return found[0], true
+}
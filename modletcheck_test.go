@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/7daystosettle/data-tool/ko"
+)
+
+func TestPatchTargets(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"Config/items.xml":                `<items/>`,
+		"Config/XUi/windows.xml":          `<windows/>`,
+		"Config/XUi_Menu/windows.xml":     `<windows/>`,
+		"Config/XUi/controls.xml":         `<controls/>`,
+		"Mod/Config/items.xml":            `<configs/>`,
+		"Mod/Config/XUi_Menu/windows.xml": `<configs/>`,
+		"controls.xml":                    `<configs/>`,
+		"windows.xml":                     `<configs/>`,
+	})
+	set, err := treeConfigSet(filepath.Join(dir, "Config"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches, err := patchFiles([]string{
+		filepath.Join(dir, "Mod", "Config"),
+		filepath.Join(dir, "controls.xml"),
+		filepath.Join(dir, "windows.xml"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range patches {
+		f, ok := set.target(p.name)
+		if !ok {
+			got = append(got, p.name+" -")
+			continue
+		}
+		got = append(got, p.name+" "+f.name)
+	}
+	want := []string{
+		"XUi_Menu/windows XUi_Menu/windows.xml",
+		"items items.xml",
+		"controls XUi/controls.xml",
+		"windows -", // ambiguous between XUi and XUi_Menu
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("targets = %q, want %q", got, want)
+	}
+}
+
+func TestCheckPatches(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"Config/blocks.xml": `<blocks><block name="a"/></blocks>`,
+		"Config/items.xml":  `<items><item name="a"/></items>`,
+		"Mod/blocks.xml":    "<configs>\n<set xpath=\"/blocks/block[@name='a']/@v\">1</set>\n",
+		"Mod/items.kdl":     "configs {\n  remove xpath=\"/items/item[@name='b']\"\n}\n",
+	})
+	set, err := treeConfigSet(filepath.Join(dir, "Config"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches, err := patchFiles([]string{filepath.Join(dir, "Mod")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	failed, err := checkPatches(&out, patches, set, "Config", ko.PatchEnv{})
+	if err != nil {
+		t.Fatalf("checkPatches: %v", err)
+	}
+	if failed != 2 {
+		t.Errorf("failed = %d, want 2", failed)
+	}
+	mod := filepath.Join(dir, "Mod")
+	want := strings.NewReplacer("$M", mod).Replace(`$M/blocks.xml:3: invalid: unexpected EOF
+$M/items.kdl: no-match: remove /items/item[@name='b'] matches nothing
+`)
+	if out.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
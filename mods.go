@@ -0,0 +1,391 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/7daystosettle/data-tool/ko"
+	"github.com/7daystosettle/data-tool/mods"
+	"github.com/7daystosettle/data-tool/project"
+)
+
+func runMods(args []string) error {
+	if len(args) == 0 {
+		printModsUsage()
+		return errUsage
+	}
+	switch args[0] {
+	case "build":
+		return runModsBuild(args[1:])
+	case "blame":
+		return runModsBlame(args[1:])
+	case "conflicts":
+		return runModsConflicts(args[1:])
+	case "help", "-h", "--help":
+		printModsUsage()
+		return nil
+	default:
+		printModsUsage()
+		return errUsage
+	}
+}
+
+func printModsUsage() {
+	fmt.Fprintf(os.Stderr, `usage: %[1]s mods <command> [flags] [args]
+
+commands:
+  build [--to xml|kdl] [--provenance] <config_dir> <mods_dir> <out_dir>
+      apply every mod in a Mods folder to the game configs as the game would
+  blame [--config-dir dir] [--mods dir] <xpath>
+      show which mods changed the nodes an XPath selects in the built configs
+  conflicts [--config-dir dir] [--mods dir] [--exit-code]
+      list what more than one mod changed, and operations earlier mods broke
+
+Run a command with -h to see its flags.
+`, os.Args[0])
+}
+
+func runModsBuild(args []string) error {
+	fs := flag.NewFlagSet("mods build", flag.ContinueOnError)
+	to := fs.String("to", "", "write only xml or kdl (default: both)")
+	provenance := fs.Bool("provenance", false, "note which mod changed each element and attribute, as XML comments and KDL type annotations")
+	gameVersion := fs.String("game-version", "", "game version conditional blocks compare against")
+	strict := fs.Bool("strict", false, "fail when a patch fails or an operation matches nothing")
+	quiet := fs.Bool("q", false, "do not report operations that match nothing or skipped mods")
+	configPath := fs.String("config", "", "project file with extra identity rules (default: "+project.FileName+" in the working directory or a parent)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s mods build [flags] <config_dir> <mods_dir> <out_dir>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() != 3 {
+		fs.Usage()
+		return errUsage
+	}
+	formats := []string{formatXml, formatKdl}
+	if *to != "" {
+		format, err := parseFormat(*to)
+		if err != nil {
+			return fmt.Errorf("--to: %w", err)
+		}
+		formats = []string{format}
+	}
+
+	cfg, err := loadProject(*configPath)
+	if err != nil {
+		return err
+	}
+	loaded, skipped, err := mods.Load(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		for _, s := range skipped {
+			fmt.Fprintf(os.Stderr, "skipped %s: %v\n", s.Dir, s.Err)
+		}
+	}
+	b, err := buildMods(fs.Arg(0), loaded, *gameVersion, registryFor(cfg))
+	if err != nil {
+		return err
+	}
+
+	for i, m := range loaded {
+		fmt.Printf("%d. %s %s (%s)\n", i+1, m.Name, m.Version, filepath.Base(m.Dir))
+	}
+	failed, unmatched := 0, 0
+	for _, p := range b.patches {
+		if p.err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: %s: %v\n", p.mod.Name, p.path, p.err)
+		}
+		for _, r := range p.results {
+			if r.Op == ko.OpConditional || r.Matched > 0 {
+				continue
+			}
+			unmatched++
+			if !*quiet {
+				fmt.Fprintf(os.Stderr, "%s: %s: operation %d: %s xpath matched nothing: %s\n", p.mod.Name, p.path, r.Index, r.Op, r.XPath)
+			}
+		}
+	}
+
+	out := fs.Arg(2)
+	for _, name := range b.names {
+		path := filepath.Join(out, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			return fmt.Errorf("create output dir: %w", err)
+		}
+		doc := b.files[name]
+		if *provenance {
+			doc.SetOptions(ko.Options{Provenance: true})
+		}
+		for _, format := range formats {
+			err = writeFileAtomic(path+"."+format, false, func(w io.Writer) error {
+				return writeDoc(doc, w, format)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Printf("applied %d patch files from %d mods; wrote %d config files to %s\n", len(b.patches)-failed, len(loaded), len(b.names), out)
+	if *strict && failed+unmatched > 0 {
+		return fmt.Errorf("%d patch files failed and %d operations matched nothing", failed, unmatched)
+	}
+	return nil
+}
+
+func runModsBlame(args []string) error {
+	fs := flag.NewFlagSet("mods blame", flag.ContinueOnError)
+	mf := addModsFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s mods blame [flags] <xpath>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	b, err := mf.build()
+	if err != nil {
+		return err
+	}
+
+	found := 0
+	for _, name := range b.names {
+		blame, err := b.files[name].Blame(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("xpath: %w", err)
+		}
+		for _, bl := range blame {
+			found++
+			printBlame(os.Stdout, name, bl)
+		}
+	}
+	if found == 0 {
+		return fmt.Errorf("%s matched nothing in the built configs", fs.Arg(0))
+	}
+	return nil
+}
+
+// modsFlags are the flags of the mods commands that analyse a build of the
+// game's own Mods folder.
+type modsFlags struct {
+	configDir   string
+	modsDir     string
+	gameVersion string
+	config      string
+}
+
+func addModsFlags(fs *flag.FlagSet) *modsFlags {
+	f := &modsFlags{}
+	fs.StringVar(&f.configDir, "config-dir", "", "game config directory (default: the vanilla configs of the game install)")
+	fs.StringVar(&f.modsDir, "mods", "", "Mods folder (default: the Mods folder of the install the config directory is in)")
+	fs.StringVar(&f.gameVersion, "game-version", "", "game version conditional blocks compare against")
+	fs.StringVar(&f.config, "config", "", "project file (default: "+project.FileName+" in the working directory or a parent)")
+	return f
+}
+
+// build loads the project file and mods and builds the configs.
+func (f *modsFlags) build() (*modBuild, error) {
+	cfg, err := loadProject(f.config)
+	if err != nil {
+		return nil, err
+	}
+	configDir := f.configDir
+	if configDir == "" {
+		configDir, err = vanillaConfigDir(cfg, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+	modsDir := f.modsDir
+	if modsDir == "" {
+		// Data/Config -> Mods
+		modsDir = filepath.Join(configDir, "..", "..", "Mods")
+	}
+	loaded, _, err := mods.Load(modsDir)
+	if err != nil {
+		return nil, err
+	}
+	return buildMods(configDir, loaded, f.gameVersion, registryFor(cfg))
+}
+
+// printBlame writes the operations that changed one node, oldest first.
+func printBlame(w io.Writer, file string, b ko.Blame) {
+	if b.Removed {
+		fmt.Fprintf(w, "%s: %s = %q (removed)\n", file, b.Path, b.Value)
+	} else {
+		fmt.Fprintf(w, "%s: %s = %q\n", file, b.Path, b.Value)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	line := func(what string, o ko.Origin) {
+		fmt.Fprintf(tw, "  %s\t%s\t%s:%d\t%s\n", what, o.Mod, o.Patch, o.Line, o.Op)
+	}
+	for _, o := range b.Origins {
+		line("", o)
+	}
+	attrs := make([]string, 0, len(b.Attrs))
+	for attr := range b.Attrs {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+	for _, attr := range attrs {
+		for _, o := range b.Attrs[attr] {
+			line("@"+attr, o)
+		}
+	}
+	tw.Flush()
+	if len(b.Origins)+len(b.Attrs) == 0 {
+		fmt.Fprintln(w, "  unchanged by any mod")
+	}
+}
+
+// modBuild is the game's config files with the patches of a set of mods
+// applied.
+type modBuild struct {
+	mods []*mods.Mod
+	// files holds the patched config files by slash-separated path relative
+	// to the config directory without extension, such as XUi/windows, and
+	// names lists them in sorted order.
+	files   map[string]*ko.Ko
+	names   []string
+	patches []modPatch
+	// sources holds the path of each unpatched config file, and env and reg
+	// what the patches were applied with.
+	sources map[string]string
+	env     ko.PatchEnv
+	reg     *ko.Registry
+}
+
+// modPatch is one patch file of a mod and what applying it did.
+type modPatch struct {
+	mod *mods.Mod
+	// file is the config file patched, a key of modBuild.files, and path
+	// the patch file.
+	file    string
+	path    string
+	results []ko.PatchResult
+	err     error
+}
+
+var errNoConfigFile = errors.New("the game has no config file of that name")
+
+// buildMods applies the Config patches of each mod, in order, to the config
+// files in configDir. Conditional blocks see every mod as loaded, as they do
+// in the game, and the given game version. What each operation changes is
+// recorded with its mod and patch file. A patch file that cannot be read
+// or applied is recorded and skipped, leaving the operations before the
+// failing one applied, as the game does.
+func buildMods(configDir string, loaded []*mods.Mod, gameVersion string, reg *ko.Registry) (*modBuild, error) {
+	paths, err := configTree(configDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s: no XML or KDL files found", configDir)
+	}
+	b := &modBuild{mods: loaded, files: make(map[string]*ko.Ko), sources: paths, reg: reg}
+	for name, path := range paths {
+		doc, err := loadFile(path, reg)
+		if err != nil {
+			return nil, err
+		}
+		b.files[name] = doc
+		b.names = append(b.names, name)
+	}
+	sort.Strings(b.names)
+
+	b.env = ko.PatchEnv{Mods: make(map[string]string), GameVersion: gameVersion}
+	for _, m := range loaded {
+		b.env.Mods[m.Name] = m.Version
+	}
+	for _, m := range loaded {
+		patches, err := configTree(m.ConfigDir())
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // a mod of assets or code only
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m.Name, err)
+		}
+		names := make([]string, 0, len(patches))
+		for name := range patches {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p := modPatch{mod: m, file: name, path: patches[name]}
+			doc, ok := b.files[name]
+			if !ok {
+				p.err = errNoConfigFile
+				b.patches = append(b.patches, p)
+				continue
+			}
+			p.results, p.err = b.apply(doc, m, p.path)
+			b.patches = append(b.patches, p)
+		}
+	}
+	return b, nil
+}
+
+// apply applies the patch file at path of mod m to doc.
+func (b *modBuild) apply(doc *ko.Ko, m *mods.Mod, path string) ([]ko.PatchResult, error) {
+	patch, err := loadFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	env := b.env
+	env.Mod, env.Patch = m.Name, path
+	return doc.ApplyPatchEnv(patch, env)
+}
+
+// configTree returns the XML and KDL files under dir by slash-separated path
+// relative to dir without extension. The XML file wins when both forms are
+// present.
+func configTree(dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || formatFromPath(p) == "" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel)))
+		if _, dup := files[name]; dup && formatFromPath(p) != formatXml {
+			return nil
+		}
+		files[name] = p
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read config dir: %w", err)
+	}
+	return files, nil
+}
@@ -0,0 +1,159 @@
+// Package mods reads a 7 Days to Die Mods folder: each mod's ModInfo.xml and
+// the order the game loads the mods in.
+package mods
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// InfoFileName is the file that makes a folder of the Mods folder a mod.
+const InfoFileName = "ModInfo.xml"
+
+// ConfigDirName is the folder of a mod holding its XPath patches, named
+// after the game config files they patch.
+const ConfigDirName = "Config"
+
+// Mod is one mod, as described by its ModInfo.xml.
+type Mod struct {
+	// Name identifies the mod; conditions such as mod_loaded('name') test
+	// it.
+	Name        string
+	DisplayName string
+	Version     string
+	Description string
+	Author      string
+	Website     string
+	// Dir is the mod's folder.
+	Dir string
+	// Schema is the ModInfo.xml layout: 1 for the original one, with the
+	// fields inside a ModInfo element, or 2 for the flat one of Alpha 21 and
+	// later.
+	Schema int
+}
+
+// ConfigDir returns the mod's Config folder.
+func (m *Mod) ConfigDir() string {
+	return filepath.Join(m.Dir, ConfigDirName)
+}
+
+type infoValue struct {
+	Value string `xml:"value,attr"`
+}
+
+type infoFields struct {
+	Name        *infoValue
+	DisplayName *infoValue
+	Version     *infoValue
+	Description *infoValue
+	Author      *infoValue
+	Website     *infoValue
+}
+
+type infoFile struct {
+	infoFields
+	ModInfo *infoFields
+}
+
+// ReadInfo reads the ModInfo.xml of the mod in dir. Both schemas are
+// understood:
+//
+//	<xml><ModInfo><Name value="MyMod"/><Version value="1.0"/></ModInfo></xml>
+//	<xml><Name value="MyMod"/><DisplayName value="My Mod"/><Version value="1.0"/></xml>
+func ReadInfo(dir string) (*Mod, error) {
+	data, err := os.ReadFile(filepath.Join(dir, InfoFileName))
+	if err != nil {
+		return nil, fmt.Errorf("read mod info: %w", err)
+	}
+	var f infoFile
+	err = xml.Unmarshal(data, &f)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", InfoFileName, err)
+	}
+	m := &Mod{Dir: dir, Schema: 2}
+	fields := &f.infoFields
+	if f.ModInfo != nil {
+		m.Schema = 1
+		fields = f.ModInfo
+	}
+	value := func(v *infoValue) string {
+		if v == nil {
+			return ""
+		}
+		return strings.TrimSpace(v.Value)
+	}
+	m.Name = value(fields.Name)
+	m.DisplayName = value(fields.DisplayName)
+	m.Version = value(fields.Version)
+	m.Description = value(fields.Description)
+	m.Author = value(fields.Author)
+	m.Website = value(fields.Website)
+	if m.Name == "" {
+		return nil, fmt.Errorf("%s: no Name", InfoFileName)
+	}
+	if m.DisplayName == "" {
+		m.DisplayName = m.Name
+	}
+	return m, nil
+}
+
+// Skipped is a folder of the Mods folder that was not loaded, and why.
+type Skipped struct {
+	Dir string
+	Err error
+}
+
+// ErrNoInfo is the Skipped error of folders without a ModInfo.xml.
+var ErrNoInfo = errors.New("no " + InfoFileName)
+
+// Load returns the mods in dir in the order the game loads them: by folder
+// name, ignoring case, which is why mods that others build on are often
+// named with a leading 0-. Like the game, it skips folders without a
+// readable ModInfo.xml and any mod whose name an earlier mod already has.
+func Load(dir string) ([]*Mod, []Skipped, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read mods dir: %w", err)
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := strings.ToLower(names[i]), strings.ToLower(names[j])
+		if a != b {
+			return a < b
+		}
+		return names[i] < names[j]
+	})
+
+	var mods []*Mod
+	var skipped []Skipped
+	loaded := make(map[string]*Mod)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(filepath.Join(path, InfoFileName)); errors.Is(err, os.ErrNotExist) {
+			skipped = append(skipped, Skipped{Dir: path, Err: ErrNoInfo})
+			continue
+		}
+		m, err := ReadInfo(path)
+		if err != nil {
+			skipped = append(skipped, Skipped{Dir: path, Err: err})
+			continue
+		}
+		if prior, ok := loaded[m.Name]; ok {
+			skipped = append(skipped, Skipped{Dir: path, Err: fmt.Errorf("mod %s is already loaded from %s", m.Name, prior.Dir)})
+			continue
+		}
+		loaded[m.Name] = m
+		mods = append(mods, m)
+	}
+	return mods, skipped, nil
+}
@@ -0,0 +1,79 @@
+package mods
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeMod(t *testing.T, dir, folder, info string) {
+	t.Helper()
+	path := filepath.Join(dir, folder)
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if info == "" {
+		return
+	}
+	if err := os.WriteFile(filepath.Join(path, InfoFileName), []byte(info), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeMod(t, dir, "zombies", `<?xml version="1.0" encoding="UTF-8"?>
+<xml>
+	<Name value="MoreZombies"/>
+	<DisplayName value="More Zombies"/>
+	<Version value="2.1.0"/>
+	<Author value="someone"/>
+</xml>`)
+	writeMod(t, dir, "0-Core", `<xml>
+	<ModInfo>
+		<Name value="Core"/>
+		<Description value="shared code"/>
+		<Version value="1.0" compat="A20"/>
+	</ModInfo>
+</xml>`)
+	writeMod(t, dir, "Backpack", `<xml><Name value="Backpack"/></xml>`)
+	writeMod(t, dir, "zombies-copy", `<xml><Name value="MoreZombies"/></xml>`)
+	writeMod(t, dir, "empty", "")
+	writeMod(t, dir, "broken", `<xml><Version value="1"/></xml>`)
+	if err := os.WriteFile(filepath.Join(dir, "readme.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mods, skipped, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	var names []string
+	for _, m := range mods {
+		names = append(names, m.Name)
+	}
+	if want := []string{"Core", "Backpack", "MoreZombies"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("load order = %q, want %q", names, want)
+	}
+	core := *mods[0]
+	if want := (Mod{Name: "Core", DisplayName: "Core", Version: "1.0", Description: "shared code", Dir: filepath.Join(dir, "0-Core"), Schema: 1}); core != want {
+		t.Errorf("v1 mod = %+v, want %+v", core, want)
+	}
+	zombies := *mods[2]
+	if want := (Mod{Name: "MoreZombies", DisplayName: "More Zombies", Version: "2.1.0", Author: "someone", Dir: filepath.Join(dir, "zombies"), Schema: 2}); zombies != want {
+		t.Errorf("v2 mod = %+v, want %+v", zombies, want)
+	}
+
+	var skippedDirs []string
+	for _, s := range skipped {
+		skippedDirs = append(skippedDirs, filepath.Base(s.Dir))
+	}
+	if want := []string{"broken", "empty", "zombies-copy"}; !reflect.DeepEqual(skippedDirs, want) {
+		t.Errorf("skipped = %q, want %q", skippedDirs, want)
+	}
+	if !errors.Is(skipped[1].Err, ErrNoInfo) {
+		t.Errorf("empty folder skipped with %v, want %v", skipped[1].Err, ErrNoInfo)
+	}
+}
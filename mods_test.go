@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/7daystosettle/data-tool/ko"
+	"github.com/7daystosettle/data-tool/mods"
+)
+
+// writeTree writes files, keyed by slash-separated path, under dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuildMods(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"Config/items.xml":       `<items><item name="gunPistol"><property name="EntityDamage" value="30"/></item></items>`,
+		"Config/XUi/windows.xml": `<windows><window name="HUD"/></windows>`,
+
+		"Mods/b-Balance/ModInfo.xml":      `<xml><Name value="Balance"/><Version value="2.0"/></xml>`,
+		"Mods/b-Balance/Config/items.xml": `<configs><set xpath="//item[@name='gunPistol']/property[@name='EntityDamage']/@value">40</set></configs>`,
+		"Mods/a-Core/ModInfo.xml":         `<xml><ModInfo><Name value="Core"/></ModInfo></xml>`,
+		"Mods/a-Core/Config/items.xml": `<configs>
+			<set xpath="//item[@name='gunPistol']/property[@name='EntityDamage']/@value">35</set>
+			<conditional><if cond="mod_loaded('Balance')"><append xpath="/items"><item name="balanced"/></append></if></conditional>
+		</configs>`,
+		"Mods/a-Core/Config/XUi/windows.xml": `<configs><remove xpath="//window[@name='Missing']"/></configs>`,
+		"Mods/a-Core/Config/unknown.xml":     `<configs/>`,
+	})
+
+	loaded, _, err := mods.Load(filepath.Join(dir, "Mods"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := buildMods(filepath.Join(dir, "Config"), loaded, "", nil)
+	if err != nil {
+		t.Fatalf("buildMods: %v", err)
+	}
+
+	// Balance loads after Core, so its value wins; Core's conditional sees
+	// Balance loaded even though it comes later.
+	want, err := ko.NewFromXml(strings.NewReader(`<items><item name="gunPistol"><property name="EntityDamage" value="40"/></item><item name="balanced"/></items>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs := ko.Compare(b.files["items"], want, ko.CompareOptions{}); len(diffs) > 0 {
+		t.Errorf("items differ: %v", diffs)
+	}
+	if _, ok := b.files["XUi/windows"]; !ok {
+		t.Errorf("files = %v, want XUi/windows among them", b.names)
+	}
+
+	var order []string
+	for _, p := range b.patches {
+		order = append(order, p.mod.Name+":"+p.file)
+	}
+	if want := "[Core:XUi/windows Core:items Core:unknown Balance:items]"; fmt.Sprint(order) != want {
+		t.Errorf("patches applied = %s, want %s", fmt.Sprint(order), want)
+	}
+	if !errors.Is(b.patches[2].err, errNoConfigFile) {
+		t.Errorf("patch of a missing config file: err = %v, want %v", b.patches[2].err, errNoConfigFile)
+	}
+	if r := b.patches[0].results; len(r) != 1 || r[0].Matched != 0 {
+		t.Errorf("windows patch results = %+v, want one unmatched remove", r)
+	}
+
+	blame, err := b.files["items"].Blame("//property[@name='EntityDamage']/@value")
+	if err != nil {
+		t.Fatalf("Blame: %v", err)
+	}
+	var got bytes.Buffer
+	printBlame(&got, "items", blame[0])
+	core := filepath.Join(dir, "Mods", "a-Core", "Config", "items.xml")
+	balance := filepath.Join(dir, "Mods", "b-Balance", "Config", "items.xml")
+	wantBlame := fmt.Sprintf(`items: /items/item[@name='gunPistol']/property[@name='EntityDamage']/@value = "40"
+    Core     %s:2     set
+    Balance  %s:1  set
+`, core, balance)
+	if got.String() != wantBlame {
+		t.Errorf("printBlame:\n%s\nwant:\n%s", got.String(), wantBlame)
+	}
+}
+
+func TestModConflicts(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"Config/items.xml": `<items><item name="a" v="1"/><item name="b" v="1"/><item name="c" v="1"/><item name="e" v="1"/></items>`,
+
+		// Fails after its remove, which stays applied.
+		"Mods/0-Broken/ModInfo.xml": `<xml><Name value="Broken"/></xml>`,
+		"Mods/0-Broken/Config/items.xml": `<configs>
+	<remove xpath="//item[@name='e']"/>
+	<frobnicate xpath="/items"/>
+</configs>`,
+
+		"Mods/A/ModInfo.xml": `<xml><Name value="A"/></xml>`,
+		"Mods/A/Config/items.xml": `<configs>
+	<set xpath="//item[@name='a']/@v">2</set>
+	<set xpath="//item[@name='b']/@v">2</set>
+	<remove xpath="//item[@name='c']"/>
+</configs>`,
+		"Mods/B/ModInfo.xml": `<xml><Name value="B"/></xml>`,
+		"Mods/B/Config/items.xml": `<configs>
+	<set xpath="//item[@name='a']/@v">3</set>
+	<remove xpath="//item[@name='b']"/>
+	<set xpath="//item[@name='c']/@v">3</set>
+	<remove xpath="//item[@name='d']"/>
+	<set xpath="//item[@name='e']/@v">3</set>
+</configs>`,
+	})
+	loaded, _, err := mods.Load(filepath.Join(dir, "Mods"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := buildMods(filepath.Join(dir, "Config"), loaded, "", nil)
+	if err != nil {
+		t.Fatalf("buildMods: %v", err)
+	}
+	conflicts := findModConflicts(b)
+	noOps, err := findModNoOps(b)
+	if err != nil {
+		t.Fatalf("findModNoOps: %v", err)
+	}
+
+	var got bytes.Buffer
+	printModConflicts(&got, conflicts, noOps)
+	a := filepath.Join(dir, "Mods", "A", "Config", "items.xml")
+	bp := filepath.Join(dir, "Mods", "B", "Config", "items.xml")
+	want := strings.NewReplacer("$A", a, "$B", bp).Replace(`items: /items/item[@name='a']/@v
+    A  $A:2  set
+    B  $B:2  set  (wins)
+items: /items/item[@name='b'] (removed)
+    A  $A:3  set
+    B  $B:3  remove  (wins)
+B: $B:6: set //item[@name='e']/@v matches nothing after Broken
+B: $B:4: set //item[@name='c']/@v matches nothing after A
+`)
+	if got.String() != want {
+		t.Errorf("conflicts:\n%s\nwant:\n%s", got.String(), want)
+	}
+}
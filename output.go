@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Policies for an output file that already exists.
+const (
+	existingOverwrite = "overwrite"
+	existingSkip      = "skip-existing"
+	existingFail      = "fail-if-exists"
+)
+
+// errSkipped is returned when an output was left alone because it exists and
+// the policy is existingSkip.
+var errSkipped = errors.New("output exists")
+
+// checkExisting applies policy to path before any work is done for it.
+func checkExisting(path, policy string) error {
+	if policy == "" || policy == existingOverwrite {
+		return nil
+	}
+	_, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("stat output: %w", err)
+	}
+	if policy == existingSkip {
+		return errSkipped
+	}
+	return fmt.Errorf("%s already exists", path)
+}
+
+// writeFileAtomic writes path through a temporary file in the same directory
+// that is renamed into place only once write succeeds, so a failed encode
+// never leaves a truncated file behind. With backup set, a file being
+// replaced is first kept as path.bak; path itself is only ever replaced by
+// the one rename.
+func writeFileAtomic(path string, backup bool, write func(w io.Writer) error) (err error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temp file for %s: %w", base, err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	err = write(tmp)
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0o644)
+	info, statErr := os.Stat(path)
+	if statErr == nil {
+		mode = info.Mode().Perm()
+	}
+	err = tmp.Chmod(mode)
+	if err != nil {
+		return fmt.Errorf("chmod temp file: %w", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+
+	if backup && statErr == nil {
+		err = backupFile(path)
+		if err != nil {
+			return fmt.Errorf("back up %s: %w", base, err)
+		}
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("rename temp file to %s: %w", base, err)
+	}
+	return nil
+}
+
+// backupFile keeps a copy of path as path.bak, replacing any earlier one. It
+// hard links the file when it can and copies it otherwise, so path stays in
+// place either way.
+func backupFile(path string) error {
+	bak := path + ".bak"
+	err := os.Remove(bak)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if os.Link(path, bak) == nil {
+		return nil
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(bak, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertFailureKeepsExistingOutput(t *testing.T) {
+	dir := t.TempDir()
+	inFile := filepath.Join(dir, "items.kdl")
+	outFile := filepath.Join(dir, "items.xml")
+	if err := os.WriteFile(inFile, []byte("items {\n}}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(outFile, []byte("<items/>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := convert(inFile, outFile, convertOptions{}); err == nil {
+		t.Fatal("expected a parse error")
+	}
+	if b, _ := os.ReadFile(outFile); string(b) != "<items/>" {
+		t.Errorf("existing output was modified: %q", b)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("temp files left behind: %v", entries)
+	}
+}
+
+func TestConvertExistingPolicies(t *testing.T) {
+	dir := t.TempDir()
+	inFile := filepath.Join(dir, "items.kdl")
+	outFile := filepath.Join(dir, "items.xml")
+	if err := os.WriteFile(inFile, []byte("items {\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(outFile, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := convert(inFile, outFile, convertOptions{existing: existingSkip}); !errors.Is(err, errSkipped) {
+		t.Errorf("skip-existing: got %v, want errSkipped", err)
+	}
+	if _, err := convert(inFile, outFile, convertOptions{existing: existingFail}); err == nil {
+		t.Error("fail-if-exists: expected an error")
+	}
+
+	if _, err := convert(inFile, outFile, convertOptions{existing: existingOverwrite, backup: true}); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+	if b, _ := os.ReadFile(outFile + ".bak"); string(b) != "old" {
+		t.Errorf("backup holds %q, want the old output", b)
+	}
+	converted, _ := os.ReadFile(outFile)
+	if string(converted) == "old" {
+		t.Error("output was not replaced")
+	}
+
+	// A second backup replaces the first.
+	if err := os.WriteFile(inFile, []byte("items {\n  item name=a\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := convert(inFile, outFile, convertOptions{existing: existingOverwrite, backup: true}); err != nil {
+		t.Fatalf("overwrite again: %v", err)
+	}
+	if b, _ := os.ReadFile(outFile + ".bak"); string(b) != string(converted) {
+		t.Errorf("backup holds %q, want %q", b, converted)
+	}
+}
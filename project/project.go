@@ -0,0 +1,268 @@
+// Package project loads datatool.kdl, the per-project configuration file that
+// records where the game and a mod's sources live and how output is written.
+//
+// A project file looks like:
+//
+//	game "F:/SteamLibrary/steamapps/common/7 Days To Die"
+//	steam "D:/Steam"
+//	source "src"
+//	output "Config"
+//	store "snapshots"
+//	to "xml"
+//	format indent=4 profile="vanilla"
+//	profile "vanilla" "name" "value" "param1"
+//	file "items.kdl" output="items.xml" profile="vanilla"
+//	identity "items/item/effect_group/triggered_effect" "action" "cvar" file="items"
+//
+// Relative paths are resolved against the directory holding the project file.
+package project
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	kdl "github.com/sblinch/kdl-go"
+	"github.com/sblinch/kdl-go/document"
+)
+
+// FileName is the name searched for by Find.
+const FileName = "datatool.kdl"
+
+// Config is a parsed project file. Zero values mean "not configured".
+type Config struct {
+	// Path is the file the config was loaded from, if any.
+	Path string
+	// Game is the 7 Days to Die install directory.
+	Game string
+	// Steam is the Steam install searched for the game when Game is empty.
+	Steam string
+	// Source and Output are the default convert input and output paths.
+	Source string
+	Output string
+	// Store is the snapshot store directory.
+	Store string
+	// To is the default output format, xml or kdl.
+	To string
+	// Indent is the number of spaces written per nesting level.
+	Indent int
+	// Profile names the attribute ordering profile used by default.
+	Profile string
+	// Profiles maps a profile name to the attributes written first, in order.
+	Profiles map[string][]string
+	// Files holds per-file overrides, in declaration order.
+	Files []FileMapping
+	// Identities adds to the built-in rules for what identifies an element,
+	// in declaration order.
+	Identities []Identity
+}
+
+// Identity declares the attributes that identify elements at Path, an
+// element path from the root element, optionally only in File, a config file
+// base name such as "recipes".
+type Identity struct {
+	File string
+	Path string
+	Keys []string
+}
+
+// FileMapping overrides where and how one source file is converted.
+type FileMapping struct {
+	// Source is the source file path relative to Config.Source.
+	Source string
+	// Output is the output path relative to Config.Output.
+	Output string
+	// Profile overrides Config.Profile for this file.
+	Profile string
+}
+
+// Find looks for FileName in dir and each of its parents and returns the path
+// of the first one found, or "" if there is none.
+func Find(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("filepath.Abs: %w", err)
+	}
+	for {
+		p := filepath.Join(dir, FileName)
+		_, err := os.Stat(p)
+		if err == nil {
+			return p, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("stat %s: %w", p, err)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// Load reads the project file at path.
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open project file: %w", err)
+	}
+	defer f.Close()
+
+	c, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	c.Path = path
+	c.resolve(filepath.Dir(path))
+	return c, nil
+}
+
+// Parse reads a project file from r. Paths are left as written.
+func Parse(r io.Reader) (*Config, error) {
+	doc, err := kdl.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("kdl.Parse: %w", err)
+	}
+
+	c := &Config{Profiles: make(map[string][]string)}
+	for _, n := range doc.Nodes {
+		name := n.Name.NodeNameString()
+		switch name {
+		case "game":
+			c.Game, err = stringArg(n)
+		case "steam":
+			c.Steam, err = stringArg(n)
+		case "source":
+			c.Source, err = stringArg(n)
+		case "output":
+			c.Output, err = stringArg(n)
+		case "store":
+			c.Store, err = stringArg(n)
+		case "to":
+			c.To, err = stringArg(n)
+		case "format":
+			err = c.parseFormat(n)
+		case "profile":
+			err = c.parseProfile(n)
+		case "file":
+			err = c.parseFile(n)
+		case "identity":
+			err = c.parseIdentity(n)
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	if c.Profile != "" && c.Profiles[c.Profile] == nil {
+		return nil, fmt.Errorf("format: unknown profile %q", c.Profile)
+	}
+	for _, f := range c.Files {
+		if f.Profile != "" && c.Profiles[f.Profile] == nil {
+			return nil, fmt.Errorf("file %q: unknown profile %q", f.Source, f.Profile)
+		}
+	}
+	return c, nil
+}
+
+func (c *Config) parseFormat(n *document.Node) error {
+	for k, v := range n.Properties {
+		switch k {
+		case "indent":
+			i, err := strconv.Atoi(v.ValueString())
+			if err != nil || i < 0 {
+				return fmt.Errorf("indent must be a non-negative number, got %s", v.ValueString())
+			}
+			c.Indent = i
+		case "profile":
+			c.Profile = v.ValueString()
+		default:
+			return fmt.Errorf("unknown property %q", k)
+		}
+	}
+	return nil
+}
+
+func (c *Config) parseProfile(n *document.Node) error {
+	if len(n.Arguments) < 1 {
+		return fmt.Errorf("expected a profile name")
+	}
+	name := n.Arguments[0].ValueString()
+	attrs := make([]string, 0, len(n.Arguments)-1)
+	for _, a := range n.Arguments[1:] {
+		attrs = append(attrs, a.ValueString())
+	}
+	c.Profiles[name] = attrs
+	return nil
+}
+
+func (c *Config) parseFile(n *document.Node) error {
+	src, err := stringArg(n)
+	if err != nil {
+		return err
+	}
+	m := FileMapping{Source: filepath.FromSlash(src)}
+	for k, v := range n.Properties {
+		switch k {
+		case "output":
+			m.Output = filepath.FromSlash(v.ValueString())
+		case "profile":
+			m.Profile = v.ValueString()
+		default:
+			return fmt.Errorf("unknown property %q", k)
+		}
+	}
+	c.Files = append(c.Files, m)
+	return nil
+}
+
+func (c *Config) parseIdentity(n *document.Node) error {
+	if len(n.Arguments) < 2 {
+		return fmt.Errorf("expected an element path and at least one key attribute")
+	}
+	id := Identity{Path: n.Arguments[0].ValueString()}
+	for _, a := range n.Arguments[1:] {
+		id.Keys = append(id.Keys, a.ValueString())
+	}
+	for k, v := range n.Properties {
+		if k != "file" {
+			return fmt.Errorf("unknown property %q", k)
+		}
+		id.File = v.ValueString()
+	}
+	c.Identities = append(c.Identities, id)
+	return nil
+}
+
+func stringArg(n *document.Node) (string, error) {
+	if len(n.Arguments) != 1 {
+		return "", fmt.Errorf("expected exactly one value")
+	}
+	return n.Arguments[0].ValueString(), nil
+}
+
+// resolve makes the configured directories absolute relative to dir.
+func (c *Config) resolve(dir string) {
+	for _, p := range []*string{&c.Game, &c.Steam, &c.Source, &c.Output, &c.Store} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, filepath.FromSlash(*p))
+		}
+	}
+}
+
+// Mapping returns the override for the source file at rel, a path relative to
+// Source, or nil if there is none.
+func (c *Config) Mapping(rel string) *FileMapping {
+	for i := range c.Files {
+		if strings.EqualFold(filepath.Clean(c.Files[i].Source), filepath.Clean(rel)) {
+			return &c.Files[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sampleProject = `
+game "game"
+source "src"
+output "out"
+store "snapshots"
+to "xml"
+format indent=4 profile="short"
+profile "short" "name" "value"
+file "items.kdl" output="Config/items.xml" profile="short"
+identity "items/item/effect_group/triggered_effect" "action" "cvar" file="items"
+`
+
+func TestLoad(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, FileName), []byte(sampleProject), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	nested := filepath.Join(root, "src", "mods")
+	if err := os.MkdirAll(nested, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := Find(nested)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if path != filepath.Join(root, FileName) {
+		t.Fatalf("Find = %q, want the project file in %s", path, root)
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if c.Source != filepath.Join(root, "src") || c.Output != filepath.Join(root, "out") {
+		t.Errorf("paths not resolved against the project dir: source=%q output=%q", c.Source, c.Output)
+	}
+	if c.Game != filepath.Join(root, "game") {
+		t.Errorf("Game = %q", c.Game)
+	}
+	if c.Store != filepath.Join(root, "snapshots") {
+		t.Errorf("Store = %q", c.Store)
+	}
+	if c.To != "xml" || c.Indent != 4 || c.Profile != "short" {
+		t.Errorf("unexpected settings: to=%q indent=%d profile=%q", c.To, c.Indent, c.Profile)
+	}
+	if !reflect.DeepEqual(c.Profiles["short"], []string{"name", "value"}) {
+		t.Errorf("profile short = %v", c.Profiles["short"])
+	}
+	wantID := []Identity{{File: "items", Path: "items/item/effect_group/triggered_effect", Keys: []string{"action", "cvar"}}}
+	if !reflect.DeepEqual(c.Identities, wantID) {
+		t.Errorf("Identities = %+v", c.Identities)
+	}
+	m := c.Mapping("items.kdl")
+	if m == nil || m.Output != filepath.FromSlash("Config/items.xml") || m.Profile != "short" {
+		t.Errorf("Mapping(items.kdl) = %+v", m)
+	}
+}
+
+func TestParseRejectsUnknownProfile(t *testing.T) {
+	_, err := Parse(strings.NewReader(`format profile="missing"`))
+	if err == nil {
+		t.Fatal("expected an error for an undeclared profile")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/7daystosettle/data-tool/ko"
+)
+
+// File statuses used in reports.
+const (
+	statusConverted = "converted"
+	statusUnchanged = "unchanged"
+	statusSkipped   = "skipped"
+	statusFailed    = "failed"
+)
+
+// convertResult describes one successful conversion.
+type convertResult struct {
+	status      string
+	from        string
+	to          string
+	inputBytes  int64
+	outputBytes int64
+	stats       ko.Stats
+	warnings    []string
+}
+
+// runReport is the machine-readable summary written by --report json.
+type runReport struct {
+	ToolVersion string       `json:"tool_version"`
+	Started     time.Time    `json:"started"`
+	DurationMs  float64      `json:"duration_ms"`
+	Converted   int          `json:"converted"`
+	Unchanged   int          `json:"unchanged"`
+	Skipped     int          `json:"skipped"`
+	Failed      int          `json:"failed"`
+	Files       []fileReport `json:"files"`
+}
+
+type fileReport struct {
+	Input       string        `json:"input"`
+	Output      string        `json:"output"`
+	From        string        `json:"from,omitempty"`
+	To          string        `json:"to,omitempty"`
+	Status      string        `json:"status"`
+	InputBytes  int64         `json:"input_bytes"`
+	OutputBytes int64         `json:"output_bytes"`
+	Nodes       *ko.Stats     `json:"nodes,omitempty"`
+	DurationMs  float64       `json:"duration_ms"`
+	Warnings    []string      `json:"warnings,omitempty"`
+	Errors      []reportError `json:"errors,omitempty"`
+}
+
+type reportError struct {
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+}
+
+func newRunReport() *runReport {
+	return &runReport{ToolVersion: toolVersion(), Started: time.Now(), Files: []fileReport{}}
+}
+
+// add records the outcome of converting in to out, which took d.
+func (r *runReport) add(in, out string, res convertResult, err error, d time.Duration) {
+	fr := fileReport{
+		Input:       in,
+		Output:      out,
+		From:        res.from,
+		To:          res.to,
+		Status:      res.status,
+		InputBytes:  res.inputBytes,
+		OutputBytes: res.outputBytes,
+		DurationMs:  float64(d.Microseconds()) / 1000,
+		Warnings:    res.warnings,
+	}
+	if res.status == statusConverted {
+		stats := res.stats
+		fr.Nodes = &stats
+	}
+	switch {
+	case errors.Is(err, errSkipped):
+		fr.Status = statusSkipped
+	case err != nil:
+		fr.Status = statusFailed
+		re := reportError{Message: err.Error()}
+		var perr *ko.ParseError
+		if errors.As(err, &perr) {
+			re.Line, re.Column = perr.Line, perr.Column
+		}
+		fr.Errors = append(fr.Errors, re)
+	}
+
+	switch fr.Status {
+	case statusConverted:
+		r.Converted++
+	case statusUnchanged:
+		r.Unchanged++
+	case statusSkipped:
+		r.Skipped++
+	case statusFailed:
+		r.Failed++
+	}
+	r.Files = append(r.Files, fr)
+}
+
+func (r *runReport) write(w io.Writer) error {
+	r.DurationMs = float64(time.Since(r.Started).Microseconds()) / 1000
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(r)
+	if err != nil {
+		return fmt.Errorf("encode report: %w", err)
+	}
+	return nil
+}
+
+// countingReader and countingWriter tally the bytes passing through them.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertJsonReport(t *testing.T) {
+	src := t.TempDir()
+	out := t.TempDir()
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+	if err := os.WriteFile(filepath.Join(src, "items.xml"), []byte(sampleXml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "broken.kdl"), []byte("items {\n}}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := runConvert([]string{"--config", filepath.Join(t.TempDir(), "none.kdl"), "--report", "json", "--report-out", reportFile, src, out})
+	if err == nil {
+		t.Fatal("expected an error loading a missing project file")
+	}
+
+	err = runConvert([]string{"--report", "json", "--report-out", reportFile, src, out})
+	if err != nil {
+		t.Fatalf("runConvert: %v", err)
+	}
+	b, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report runReport
+	if err := json.Unmarshal(b, &report); err != nil {
+		t.Fatalf("report is not valid json: %v\n%s", err, b)
+	}
+	if report.Converted != 1 || report.Failed != 1 || len(report.Files) != 2 {
+		t.Fatalf("unexpected totals:\n%s", b)
+	}
+
+	files := map[string]fileReport{}
+	for _, f := range report.Files {
+		files[filepath.Base(f.Input)] = f
+	}
+	broken := files["broken.kdl"]
+	if broken.Status != statusFailed || len(broken.Errors) != 1 || broken.Errors[0].Line != 2 {
+		t.Errorf("unexpected report for broken.kdl: %+v", broken)
+	}
+	items := files["items.xml"]
+	if items.Status != statusConverted || items.Nodes == nil || items.Nodes.Elements != 3 || items.InputBytes != int64(len(sampleXml)) || items.OutputBytes == 0 {
+		t.Errorf("unexpected report for items.xml: %+v", items)
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/7daystosettle/data-tool/ko"
+	"github.com/7daystosettle/data-tool/project"
+	"github.com/7daystosettle/data-tool/snapshot"
+)
+
+func runSnapshot(args []string) error {
+	if len(args) == 0 {
+		printSnapshotUsage()
+		return errUsage
+	}
+	switch args[0] {
+	case "import":
+		return runSnapshotImport(args[1:])
+	case "list":
+		return runSnapshotList(args[1:])
+	case "export":
+		return runSnapshotExport(args[1:])
+	case "diff":
+		return runSnapshotDiff(args[1:])
+	case "help", "-h", "--help":
+		printSnapshotUsage()
+		return nil
+	default:
+		printSnapshotUsage()
+		return errUsage
+	}
+}
+
+func printSnapshotUsage() {
+	fmt.Fprintf(os.Stderr, `usage: %[1]s snapshot <command> [flags] [args]
+
+commands:
+  import --label <label> [config_dir]
+      convert a release's config files and store them under label
+  list
+      list stored snapshots
+  export [--to xml|kdl] <label> [out_dir]
+      write a stored snapshot's files back out
+  diff [-v] [--exit-code] <old-label> <new-label>
+      list entities added, removed or changed between two snapshots
+
+The store defaults to the store in datatool.kdl, else a data-tool directory
+in the user config directory. Run a command with -h to see its flags.
+`, os.Args[0])
+}
+
+// snapshotFlags are the flags shared by the snapshot commands.
+type snapshotFlags struct {
+	config string
+	store  string
+}
+
+func addSnapshotFlags(fs *flag.FlagSet) *snapshotFlags {
+	f := &snapshotFlags{}
+	fs.StringVar(&f.config, "config", "", "project file (default: "+project.FileName+" in the working directory or a parent)")
+	fs.StringVar(&f.store, "store", "", "snapshot store directory (default: store in the project file)")
+	return f
+}
+
+// open loads the project file and opens the snapshot store it selects.
+func (f *snapshotFlags) open() (*project.Config, *snapshot.Store, error) {
+	cfg, err := loadProject(f.config)
+	if err != nil {
+		return nil, nil, err
+	}
+	dir := f.store
+	if dir == "" {
+		dir = cfg.Store
+	}
+	if dir == "" {
+		userDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, nil, fmt.Errorf("no snapshot store configured: %w", err)
+		}
+		dir = filepath.Join(userDir, "data-tool", "snapshots")
+	}
+	return cfg, snapshot.Open(dir), nil
+}
+
+// parseSnapshotFlags parses args and checks that between minArgs and maxArgs
+// arguments remain. It returns false when the command should stop, with the
+// error to return.
+func parseSnapshotFlags(fs *flag.FlagSet, args []string, usage string, minArgs, maxArgs int) (bool, error) {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s\n", os.Args[0], usage)
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return false, nil
+		}
+		return false, errUsage
+	}
+	if fs.NArg() < minArgs || fs.NArg() > maxArgs {
+		fs.Usage()
+		return false, errUsage
+	}
+	return true, nil
+}
+
+func runSnapshotImport(args []string) error {
+	fs := flag.NewFlagSet("snapshot import", flag.ContinueOnError)
+	sf := addSnapshotFlags(fs)
+	label := fs.String("label", "", "name of the release, such as \"V1.2 b27\" (required)")
+	steamRoot := fs.String("steam", "", "Steam install searched when config_dir is not given")
+	ok, err := parseSnapshotFlags(fs, args, "snapshot import [flags] --label <label> [config_dir]", 0, 1)
+	if !ok {
+		return err
+	}
+	if *label == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	cfg, store, err := sf.open()
+	if err != nil {
+		return err
+	}
+	dir := fs.Arg(0)
+	if dir == "" {
+		dir, err = vanillaConfigDir(cfg, *steamRoot)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = store.Get(*label)
+	if err == nil {
+		return fmt.Errorf("snapshot %q already exists", *label)
+	}
+	if !errors.Is(err, snapshot.ErrNotFound) {
+		return err
+	}
+
+	snap, err := importSnapshot(store, dir, *label)
+	if err != nil {
+		return err
+	}
+	err = store.Add(snap)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("stored %q: %d files from %s\n", snap.Label, len(snap.Files), dir)
+	return nil
+}
+
+// importSnapshot converts every XML and KDL file under dir to KDL and puts
+// it in store, returning the snapshot that records them.
+func importSnapshot(store *snapshot.Store, dir, label string) (*snapshot.Snapshot, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("filepath.Abs: %w", err)
+	}
+	snap := &snapshot.Snapshot{
+		Label:   label,
+		Created: time.Now().UTC(),
+		Source:  abs,
+		Files:   make(map[string]string),
+	}
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || formatFromPath(p) == "" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		doc, err := loadFile(p, nil)
+		if err != nil {
+			return err
+		}
+		var b bytes.Buffer
+		err = writeDoc(doc, &b, formatKdl)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		hash, err := store.Put(b.Bytes())
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		snap.Files[filepath.ToSlash(rel)] = hash
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("import %s: %w", dir, err)
+	}
+	if len(snap.Files) == 0 {
+		return nil, fmt.Errorf("import %s: no XML or KDL files found", dir)
+	}
+	return snap, nil
+}
+
+func runSnapshotList(args []string) error {
+	fs := flag.NewFlagSet("snapshot list", flag.ContinueOnError)
+	sf := addSnapshotFlags(fs)
+	ok, err := parseSnapshotFlags(fs, args, "snapshot list [flags]", 0, 0)
+	if !ok {
+		return err
+	}
+	_, store, err := sf.open()
+	if err != nil {
+		return err
+	}
+	snaps, err := store.List()
+	if err != nil {
+		return err
+	}
+	if len(snaps) == 0 {
+		fmt.Fprintf(os.Stderr, "no snapshots in %s\n", store.Dir)
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "LABEL\tCREATED\tFILES\tSOURCE")
+	for _, s := range snaps {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", s.Label, s.Created.Local().Format("2006-01-02 15:04"), len(s.Files), s.Source)
+	}
+	return tw.Flush()
+}
+
+func runSnapshotExport(args []string) error {
+	fs := flag.NewFlagSet("snapshot export", flag.ContinueOnError)
+	sf := addSnapshotFlags(fs)
+	to := fs.String("to", formatXml, "output format, xml or kdl")
+	ok, err := parseSnapshotFlags(fs, args, "snapshot export [flags] <label> [out_dir]", 1, 2)
+	if !ok {
+		return err
+	}
+	format, err := parseFormat(*to)
+	if err != nil {
+		return fmt.Errorf("--to: %w", err)
+	}
+	if format == "" {
+		format = formatXml
+	}
+	_, store, err := sf.open()
+	if err != nil {
+		return err
+	}
+	snap, err := store.Get(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	out := fs.Arg(1)
+	if out == "" {
+		out = labelDir(snap.Label)
+	}
+
+	for _, name := range snap.Names() {
+		b, err := store.Read(snap.Files[name])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		target := filepath.Join(out, filepath.FromSlash(strings.TrimSuffix(name, path.Ext(name))+"."+format))
+		err = os.MkdirAll(filepath.Dir(target), 0o755)
+		if err != nil {
+			return fmt.Errorf("create output dir: %w", err)
+		}
+		err = writeFileAtomic(target, false, func(w io.Writer) error {
+			if format == formatKdl {
+				_, err := w.Write(b)
+				return err
+			}
+			doc, err := readDoc(bytes.NewReader(b), formatKdl)
+			if err != nil {
+				return err
+			}
+			return writeDoc(doc, w, format)
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	fmt.Printf("exported %q: %d files to %s\n", snap.Label, len(snap.Files), out)
+	return nil
+}
+
+// labelDir turns a snapshot label into a directory name.
+func labelDir(label string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?* `, r) {
+			return '_'
+		}
+		return r
+	}, label)
+}
+
+func runSnapshotDiff(args []string) error {
+	fs := flag.NewFlagSet("snapshot diff", flag.ContinueOnError)
+	sf := addSnapshotFlags(fs)
+	verbose := fs.Bool("v", false, "list the properties of added and removed entities")
+	exitCode := fs.Bool("exit-code", false, "exit with status 1 when there are changes")
+	ok, err := parseSnapshotFlags(fs, args, "snapshot diff [flags] <old-label> <new-label>", 2, 2)
+	if !ok {
+		return err
+	}
+	cfg, store, err := sf.open()
+	if err != nil {
+		return err
+	}
+	reg := registryFor(cfg)
+	old, err := snapshotConfigSet(store, fs.Arg(0), reg)
+	if err != nil {
+		return err
+	}
+	new, err := snapshotConfigSet(store, fs.Arg(1), reg)
+	if err != nil {
+		return err
+	}
+	cl, err := diffConfigSets(old, new)
+	if err != nil {
+		return err
+	}
+
+	for i, f := range cl.Files {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s (%s)\n", f.Name, f.Kind)
+		printDiff(os.Stdout, f.Entities, *verbose)
+	}
+	if *exitCode && len(cl.Files) > 0 {
+		return errNotEqual
+	}
+	return nil
+}
+
+// snapshotConfigSet returns the files of the snapshot labelled label.
+func snapshotConfigSet(store *snapshot.Store, label string, reg *ko.Registry) (configSet, error) {
+	snap, err := store.Get(label)
+	if err != nil {
+		return nil, err
+	}
+	set := make(configSet)
+	for name, hash := range snap.Files {
+		name, hash := name, hash
+		set[strings.TrimSuffix(name, path.Ext(name))] = configFile{name: name, load: func() (*ko.Ko, error) {
+			b, err := store.Read(hash)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			doc, err := readDoc(bytes.NewReader(b), formatKdl)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			doc.SetSource(name)
+			doc.SetRegistry(reg)
+			return doc, nil
+		}}
+	}
+	return set, nil
+}
@@ -0,0 +1,208 @@
+// Package snapshot keeps converted game config releases in a local
+// content-addressed store so they can be listed, exported and diffed long
+// after the install they came from has been updated.
+//
+// A store is a directory holding
+//
+//	objects/ab/cdef...   file contents, named by their sha256
+//	snapshots.json       the snapshots, each a label and a file -> object map
+//
+// Identical files shared by several releases are stored once.
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const indexFileName = "snapshots.json"
+
+// ErrNotFound is returned when no snapshot has the requested label.
+var ErrNotFound = errors.New("snapshot not found")
+
+// Snapshot is one stored release.
+type Snapshot struct {
+	Label   string    `json:"label"`
+	Created time.Time `json:"created"`
+	// Source is where the release was imported from.
+	Source string `json:"source,omitempty"`
+	// Files maps a slash-separated path relative to the imported directory,
+	// such as items.xml or XUi/windows.xml, to the hash of its content.
+	Files map[string]string `json:"files"`
+}
+
+// Names returns the snapshot's file paths in sorted order.
+func (s *Snapshot) Names() []string {
+	names := make([]string, 0, len(s.Files))
+	for name := range s.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Store is a snapshot store rooted at Dir.
+type Store struct {
+	Dir string
+}
+
+// Open returns the store at dir. The directory is created on first write.
+func Open(dir string) *Store {
+	return &Store{Dir: dir}
+}
+
+type index struct {
+	Snapshots []*Snapshot `json:"snapshots"`
+}
+
+// List returns the stored snapshots, oldest first.
+func (s *Store) List() ([]*Snapshot, error) {
+	idx, err := s.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	return idx.Snapshots, nil
+}
+
+// Get returns the snapshot labelled label.
+func (s *Store) Get(label string) (*Snapshot, error) {
+	idx, err := s.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	for _, snap := range idx.Snapshots {
+		if snap.Label == label {
+			return snap, nil
+		}
+	}
+	return nil, fmt.Errorf("%q: %w", label, ErrNotFound)
+}
+
+// Add records snap, whose files must already have been stored with Put.
+// Labels are unique.
+func (s *Store) Add(snap *Snapshot) error {
+	if snap.Label == "" {
+		return errors.New("snapshot needs a label")
+	}
+	idx, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+	for _, old := range idx.Snapshots {
+		if old.Label == snap.Label {
+			return fmt.Errorf("snapshot %q already exists", snap.Label)
+		}
+	}
+	for name, hash := range snap.Files {
+		_, err := os.Stat(s.objectPath(hash))
+		if err != nil {
+			return fmt.Errorf("%s: object %s: %w", name, hash, err)
+		}
+	}
+	idx.Snapshots = append(idx.Snapshots, snap)
+	return s.writeIndex(idx)
+}
+
+// Put stores data and returns its hash. Content already in the store is not
+// written again.
+func (s *Store) Put(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := s.objectPath(hash)
+	_, err := os.Stat(path)
+	if err == nil {
+		return hash, nil
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return "", fmt.Errorf("create object dir: %w", err)
+	}
+	err = writeFile(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// Read returns the content stored under hash.
+func (s *Store) Read(hash string) ([]byte, error) {
+	if len(hash) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid object hash %q", hash)
+	}
+	b, err := os.ReadFile(s.objectPath(hash))
+	if err != nil {
+		return nil, fmt.Errorf("read object: %w", err)
+	}
+	return b, nil
+}
+
+func (s *Store) objectPath(hash string) string {
+	return filepath.Join(s.Dir, "objects", hash[:2], hash[2:])
+}
+
+func (s *Store) readIndex() (*index, error) {
+	idx := &index{}
+	b, err := os.ReadFile(filepath.Join(s.Dir, indexFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot index: %w", err)
+	}
+	err = json.Unmarshal(b, idx)
+	if err != nil {
+		return nil, fmt.Errorf("parse snapshot index: %w", err)
+	}
+	return idx, nil
+}
+
+func (s *Store) writeIndex(idx *index) error {
+	err := os.MkdirAll(s.Dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("create store: %w", err)
+	}
+	return writeFile(filepath.Join(s.Dir, indexFileName), func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(idx)
+	})
+}
+
+// writeFile writes path through a temporary file renamed into place, so an
+// interrupted import never leaves a truncated object or index.
+func writeFile(path string, write func(w io.Writer) error) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	err = write(tmp)
+	if err != nil {
+		return fmt.Errorf("write %s: %w", filepath.Base(path), err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+	return nil
+}
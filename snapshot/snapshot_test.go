@@ -0,0 +1,73 @@
+package snapshot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	s := Open(filepath.Join(t.TempDir(), "store"))
+
+	a, err := s.Put([]byte("items {}\n"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	again, err := s.Put([]byte("items {}\n"))
+	if err != nil || again != a {
+		t.Fatalf("Put of the same content = %q, %v; want %q", again, err, a)
+	}
+	b, err := s.Put([]byte("blocks {}\n"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	objects, _ := filepath.Glob(filepath.Join(s.Dir, "objects", "*", "*"))
+	if len(objects) != 2 {
+		t.Errorf("store holds %d objects, want 2", len(objects))
+	}
+
+	first := &Snapshot{Label: "V1.0 b1", Created: time.Now(), Files: map[string]string{"items.xml": a}}
+	second := &Snapshot{Label: "V1.1 b2", Created: time.Now(), Files: map[string]string{"items.xml": a, "XUi/blocks.xml": b}}
+	for _, snap := range []*Snapshot{first, second} {
+		if err := s.Add(snap); err != nil {
+			t.Fatalf("Add(%q): %v", snap.Label, err)
+		}
+	}
+	if err := s.Add(&Snapshot{Label: "V1.0 b1"}); err == nil {
+		t.Error("Add accepted a duplicate label")
+	}
+	if err := s.Add(&Snapshot{Label: "bad", Files: map[string]string{"x.xml": strings.Repeat("0", 64)}}); err == nil {
+		t.Error("Add accepted a snapshot with a missing object")
+	}
+
+	list, err := s.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 2 || list[0].Label != "V1.0 b1" || list[1].Label != "V1.1 b2" {
+		t.Fatalf("List = %+v", list)
+	}
+
+	got, err := Open(s.Dir).Get("V1.1 b2")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if names := got.Names(); len(names) != 2 || names[0] != "XUi/blocks.xml" {
+		t.Errorf("Names = %q", names)
+	}
+	content, err := s.Read(got.Files["XUi/blocks.xml"])
+	if err != nil || string(content) != "blocks {}\n" {
+		t.Errorf("Read = %q, %v", content, err)
+	}
+
+	_, err = s.Get("missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.Dir, indexFileName)); err != nil {
+		t.Errorf("index not written: %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+// Package steam locates 7 Days to Die installs by reading the library and app
+// manifests Steam keeps on disk, so commands can find the vanilla configs
+// without being told where the game lives.
+package steam
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	// GameAppID is the Steam app id of the 7 Days to Die client.
+	GameAppID = "251570"
+	// ServerAppID is the Steam app id of the 7 Days to Die dedicated server.
+	ServerAppID = "294420"
+)
+
+// ErrNotFound is returned by Discover when no install has a Data/Config
+// directory.
+var ErrNotFound = errors.New("no 7 Days to Die install found")
+
+// Install describes one installed copy of the game or dedicated server.
+type Install struct {
+	// AppID is GameAppID or ServerAppID.
+	AppID string
+	// Name is the app name from the manifest.
+	Name string
+	// Dir is the install directory.
+	Dir string
+	// Library is the Steam library folder holding the install.
+	Library string
+	// SteamBuildID is the buildid the app manifest records for the
+	// installed files. It identifies Steam's build, not the game version
+	// such as "V1.2 b27", which the install does not keep in a file that
+	// can be read without running the game.
+	SteamBuildID string
+	// Branch is the beta branch the install tracks, "" for public.
+	Branch string
+}
+
+// ConfigDir returns the directory holding the vanilla XML configs.
+func (i Install) ConfigDir() string {
+	return filepath.Join(i.Dir, "Data", "Config")
+}
+
+// DefaultRoots returns the places Steam is installed by default on this
+// platform. Roots that do not exist are skipped by Find.
+func DefaultRoots() []string {
+	home, _ := os.UserHomeDir()
+	switch runtime.GOOS {
+	case "windows":
+		var roots []string
+		for _, env := range []string{"ProgramFiles(x86)", "ProgramFiles"} {
+			if dir := os.Getenv(env); dir != "" {
+				roots = append(roots, filepath.Join(dir, "Steam"))
+			}
+		}
+		return append(roots, `C:\Program Files (x86)\Steam`)
+	case "darwin":
+		return []string{filepath.Join(home, "Library", "Application Support", "Steam")}
+	default:
+		return []string{
+			filepath.Join(home, ".steam", "steam"),
+			filepath.Join(home, ".local", "share", "Steam"),
+			filepath.Join(home, ".var", "app", "com.valvesoftware.Steam", ".local", "share", "Steam"),
+		}
+	}
+}
+
+// Discover returns the first install under roots that has a Data/Config
+// directory, preferring the game client over the dedicated server. With no
+// roots it searches DefaultRoots.
+func Discover(roots ...string) (*Install, error) {
+	installs, err := Find(roots...)
+	if err != nil {
+		return nil, err
+	}
+	for _, appID := range []string{GameAppID, ServerAppID} {
+		for i := range installs {
+			if installs[i].AppID != appID {
+				continue
+			}
+			if info, err := os.Stat(installs[i].ConfigDir()); err == nil && info.IsDir() {
+				return &installs[i], nil
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Find returns every game and dedicated server install in the libraries of
+// the given Steam roots, or of DefaultRoots when none are given.
+func Find(roots ...string) ([]Install, error) {
+	if len(roots) == 0 {
+		roots = DefaultRoots()
+	}
+
+	var installs []Install
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		if _, err := os.Stat(filepath.Join(root, "steamapps")); err != nil {
+			continue
+		}
+		libs, err := Libraries(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, lib := range libs {
+			for _, appID := range []string{GameAppID, ServerAppID} {
+				in, err := readManifest(lib, appID)
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				key := strings.ToLower(filepath.Clean(in.Dir))
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				installs = append(installs, *in)
+			}
+		}
+	}
+	return installs, nil
+}
+
+// InstallAt returns the install in dir, read from the app manifest of the
+// Steam library holding it, for installs located without Find. It returns
+// ErrNotFound when dir is not a game or server install directly under a
+// library's steamapps/common.
+func InstallAt(dir string) (*Install, error) {
+	common := filepath.Dir(filepath.Clean(dir))
+	if !strings.EqualFold(filepath.Base(common), "common") || !strings.EqualFold(filepath.Base(filepath.Dir(common)), "steamapps") {
+		return nil, ErrNotFound
+	}
+	lib := filepath.Dir(filepath.Dir(common))
+	for _, appID := range []string{GameAppID, ServerAppID} {
+		in, err := readManifest(lib, appID)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if samePath(in.Dir, dir) {
+			return in, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Libraries returns the library folders of the Steam install at root, root
+// itself first.
+func Libraries(root string) ([]string, error) {
+	libs := []string{root}
+
+	f, err := os.Open(filepath.Join(root, "steamapps", "libraryfolders.vdf"))
+	if errors.Is(err, os.ErrNotExist) {
+		return libs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open libraryfolders.vdf: %w", err)
+	}
+	defer f.Close()
+
+	doc, err := parseVDF(f)
+	if err != nil {
+		return nil, fmt.Errorf("parse libraryfolders.vdf: %w", err)
+	}
+	folders := doc.get("libraryfolders")
+	if folders == nil {
+		return libs, nil
+	}
+	for _, c := range folders.children {
+		// Current files hold a block per library with a "path" key; older
+		// ones map the library index straight to its path.
+		p := c.value
+		if c.children != nil {
+			p = c.str("path")
+		}
+		if p == "" || !isIndex(c.key) || samePath(p, root) {
+			continue
+		}
+		libs = append(libs, p)
+	}
+	return libs, nil
+}
+
+func readManifest(lib, appID string) (*Install, error) {
+	f, err := os.Open(filepath.Join(lib, "steamapps", "appmanifest_"+appID+".acf"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	doc, err := parseVDF(f)
+	if err != nil {
+		return nil, fmt.Errorf("parse appmanifest_%s.acf: %w", appID, err)
+	}
+	state := doc.get("AppState")
+	if state == nil {
+		return nil, fmt.Errorf("appmanifest_%s.acf: missing AppState", appID)
+	}
+	installDir := state.str("installdir")
+	if installDir == "" {
+		return nil, fmt.Errorf("appmanifest_%s.acf: missing installdir", appID)
+	}
+	return &Install{
+		AppID:        appID,
+		Name:         state.str("name"),
+		Dir:          filepath.Join(lib, "steamapps", "common", installDir),
+		Library:      lib,
+		SteamBuildID: state.str("buildid"),
+		Branch:       state.str("UserConfig", "BetaKey"),
+	}, nil
+}
+
+func isIndex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func samePath(a, b string) bool {
+	return strings.EqualFold(filepath.Clean(a), filepath.Clean(b))
+}
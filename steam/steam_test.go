@@ -0,0 +1,135 @@
+package steam
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeSteam builds a Steam root whose library list points at a second
+// library holding the game, and which holds the dedicated server itself.
+func fakeSteam(t *testing.T) (root, lib string) {
+	root = t.TempDir()
+	lib = t.TempDir()
+
+	writeFile(t, filepath.Join(root, "steamapps", "libraryfolders.vdf"), `"libraryfolders"
+{
+	"0"
+	{
+		"path"		"`+strings.ReplaceAll(root, `\`, `\\`)+`"
+		"apps" { "294420" "1" }
+	}
+	"1"
+	{
+		"path"		"`+strings.ReplaceAll(lib, `\`, `\\`)+`"
+		"apps" { "251570" "1" }
+	}
+}
+`)
+	writeFile(t, filepath.Join(lib, "steamapps", "appmanifest_251570.acf"), `"AppState"
+{
+	"appid"		"251570"
+	"name"		"7 Days to Die"
+	"buildid"		"17834421"
+	"installdir"		"7 Days To Die"
+	"UserConfig"
+	{
+		"BetaKey"		"latest_experimental"
+	}
+}
+`)
+	writeFile(t, filepath.Join(lib, "steamapps", "common", "7 Days To Die", "Data", "Config", "items.xml"), "<items/>")
+	writeFile(t, filepath.Join(root, "steamapps", "appmanifest_294420.acf"), `"AppState"
+{
+	"appid"		"294420"
+	"name"		"7 Days to Die Dedicated Server"
+	"buildid"		"17834500"
+	"installdir"		"7 Days to Die Dedicated Server"
+}
+`)
+	return root, lib
+}
+
+func TestFind(t *testing.T) {
+	root, lib := fakeSteam(t)
+
+	installs, err := Find(root)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(installs) != 2 {
+		t.Fatalf("Find returned %d installs, want 2: %+v", len(installs), installs)
+	}
+	server, game := installs[0], installs[1]
+	if server.AppID != ServerAppID || server.SteamBuildID != "17834500" {
+		t.Errorf("unexpected server install %+v", server)
+	}
+	if game.AppID != GameAppID || game.Library != lib || game.SteamBuildID != "17834421" || game.Branch != "latest_experimental" {
+		t.Errorf("unexpected game install %+v", game)
+	}
+}
+
+func TestDiscoverPrefersInstallWithConfigs(t *testing.T) {
+	root, lib := fakeSteam(t)
+
+	in, err := Discover(root)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	want := filepath.Join(lib, "steamapps", "common", "7 Days To Die", "Data", "Config")
+	if in.ConfigDir() != want {
+		t.Errorf("ConfigDir = %q, want %q", in.ConfigDir(), want)
+	}
+
+	if _, err := Discover(t.TempDir()); err != ErrNotFound {
+		t.Errorf("Discover on an empty root = %v, want ErrNotFound", err)
+	}
+}
+
+func TestInstallAt(t *testing.T) {
+	_, lib := fakeSteam(t)
+
+	in, err := InstallAt(filepath.Join(lib, "steamapps", "common", "7 Days To Die"))
+	if err != nil {
+		t.Fatalf("InstallAt: %v", err)
+	}
+	if in.AppID != GameAppID || in.SteamBuildID != "17834421" {
+		t.Errorf("unexpected install %+v", in)
+	}
+
+	for _, dir := range []string{t.TempDir(), filepath.Join(lib, "steamapps", "common", "Other Game")} {
+		if _, err := InstallAt(dir); err != ErrNotFound {
+			t.Errorf("InstallAt(%s) = %v, want ErrNotFound", dir, err)
+		}
+	}
+}
+
+func TestParseVDFLegacyLibraries(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "steamapps", "libraryfolders.vdf"), `"LibraryFolders"
+{
+	// comment
+	"TimeNextStatsReport"		"1561832478"
+	"ContentStatsID"		"-158337411110787451"
+	"1"		"D:\\SteamLibrary"
+}
+`)
+	libs, err := Libraries(root)
+	if err != nil {
+		t.Fatalf("Libraries: %v", err)
+	}
+	if len(libs) != 2 || libs[1] != `D:\SteamLibrary` {
+		t.Errorf("Libraries = %q", libs)
+	}
+}
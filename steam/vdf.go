@@ -0,0 +1,166 @@
+package steam
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// kv is one entry of a Valve KeyValues (VDF/ACF) file: either a string value
+// or a block of children.
+type kv struct {
+	key      string
+	value    string
+	children []*kv
+}
+
+// get returns the first child whose key matches, ignoring case as Steam does.
+func (n *kv) get(key string) *kv {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.children {
+		if strings.EqualFold(c.key, key) {
+			return c
+		}
+	}
+	return nil
+}
+
+// str returns the value of the child at the given key path, or "".
+func (n *kv) str(path ...string) string {
+	for _, k := range path {
+		n = n.get(k)
+	}
+	if n == nil {
+		return ""
+	}
+	return n.value
+}
+
+// parseVDF parses the text KeyValues format used by libraryfolders.vdf and
+// appmanifest_*.acf and returns a synthetic root holding the top-level keys.
+func parseVDF(r io.Reader) (*kv, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	p := &vdfParser{src: string(b), line: 1}
+	root := &kv{}
+	err = p.block(root, true)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.line, err)
+	}
+	return root, nil
+}
+
+type vdfParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *vdfParser) block(parent *kv, top bool) error {
+	for {
+		tok, quoted, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case tok == "" && !quoted:
+			if !top {
+				return fmt.Errorf("unexpected end of file, missing }")
+			}
+			return nil
+		case tok == "}" && !quoted:
+			if top {
+				return fmt.Errorf("unexpected }")
+			}
+			return nil
+		case tok == "{" && !quoted:
+			return fmt.Errorf("unexpected {, expected a key")
+		}
+
+		n := &kv{key: tok}
+		val, vquoted, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case val == "{" && !vquoted:
+			err = p.block(n, false)
+			if err != nil {
+				return err
+			}
+		case (val == "" || val == "}") && !vquoted:
+			return fmt.Errorf("missing value for %q", tok)
+		default:
+			n.value = val
+		}
+		parent.children = append(parent.children, n)
+	}
+}
+
+// next returns the next token. Braces are returned as unquoted "{" and "}",
+// and the end of input as an unquoted "".
+func (p *vdfParser) next() (string, bool, error) {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case strings.HasPrefix(p.src[p.pos:], "//"):
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == '{' || c == '}':
+			p.pos++
+			return string(c), false, nil
+		case c == '"':
+			return p.quoted()
+		default:
+			start := p.pos
+			for p.pos < len(p.src) && !strings.ContainsRune(" \t\r\n{}\"", rune(p.src[p.pos])) {
+				p.pos++
+			}
+			return p.src[start:p.pos], false, nil
+		}
+	}
+	return "", false, nil
+}
+
+func (p *vdfParser) quoted() (string, bool, error) {
+	var b strings.Builder
+	p.pos++ // opening quote
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), true, nil
+		case '\n':
+			p.line++
+			b.WriteByte(c)
+		case '\\':
+			if p.pos >= len(p.src) {
+				break
+			}
+			e := p.src[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", false, fmt.Errorf("unterminated string")
+}
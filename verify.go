@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/7daystosettle/data-tool/ko"
+)
+
+// Kinds of loss found by verify.
+const (
+	lossComment    = "comment"
+	lossOrder      = "order"
+	lossWhitespace = "whitespace"
+	lossEncoding   = "encoding"
+	lossText       = "text"
+	lossElement    = "element"
+	lossAttribute  = "attribute"
+)
+
+var lossKinds = []string{lossComment, lossOrder, lossWhitespace, lossEncoding, lossText, lossElement, lossAttribute}
+
+// errVerifyFailed is returned when any file lost something not allowed.
+var errVerifyFailed = errors.New("verification failed")
+
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	allow := fs.String("allow", "", "comma separated kinds of loss that do not fail verification: "+strings.Join(lossKinds, ", "))
+	strict := fs.Bool("strict", false, "also fail when the round trip is not byte for byte identical")
+	summary := fs.Bool("summary", false, "print loss counts per file instead of every loss")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s verify [flags] <file|dir>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	allowed := make(map[string]bool)
+	for _, k := range strings.Split(*allow, ",") {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		if !contains(lossKinds, k) {
+			return fmt.Errorf("--allow: unknown kind %q", k)
+		}
+		allowed[k] = true
+	}
+
+	files, err := xmlFiles(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, path := range files {
+		res, err := verifyFile(path)
+		if err != nil {
+			fmt.Printf("%s: FAIL: %v\n", path, err)
+			failed++
+			continue
+		}
+		ok := res.passes(allowed, *strict)
+		if !ok {
+			failed++
+		}
+		res.print(os.Stdout, path, ok, *summary)
+	}
+
+	fmt.Printf("Verified %d files, %d failed\n", len(files), failed)
+	if failed > 0 {
+		return errVerifyFailed
+	}
+	return nil
+}
+
+// xmlFiles returns path itself, or the .xml files directly inside it.
+func xmlFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("stat input path: %w", err)
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("read input dir: %w", err)
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && formatFromPath(e.Name()) == formatXml {
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+	return files, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// loss is one thing the round trip did not preserve, positioned in the
+// original file.
+type loss struct {
+	kind   string
+	line   int
+	column int
+	msg    string
+}
+
+type verifyResult struct {
+	losses []loss
+	// textDiffLine is the first line that differs textually, 0 when the
+	// round trip is byte for byte identical.
+	textDiffLine int
+}
+
+func (r *verifyResult) passes(allowed map[string]bool, strict bool) bool {
+	if strict && r.textDiffLine > 0 {
+		return false
+	}
+	for _, l := range r.losses {
+		if !allowed[l.kind] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *verifyResult) print(w io.Writer, path string, ok, summary bool) {
+	verdict := "ok"
+	if !ok {
+		verdict = "FAIL"
+	}
+	textual := "textually identical"
+	if r.textDiffLine > 0 {
+		textual = fmt.Sprintf("text differs from line %d", r.textDiffLine)
+	}
+	fmt.Fprintf(w, "%s: %s (%d losses; %s)\n", path, verdict, len(r.losses), textual)
+
+	if summary {
+		counts := make(map[string]int)
+		for _, l := range r.losses {
+			counts[l.kind]++
+		}
+		for _, k := range lossKinds {
+			if counts[k] > 0 {
+				fmt.Fprintf(w, "  %s: %d\n", k, counts[k])
+			}
+		}
+		return
+	}
+	for _, l := range r.losses {
+		fmt.Fprintf(w, "  %s:%d:%d: %s: %s\n", path, l.line, l.column, l.kind, l.msg)
+	}
+}
+
+// verifyFile round-trips the XML file at path through KDL in memory and
+// reports what was lost.
+func verifyFile(path string) (*verifyResult, error) {
+	orig, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	out, err := roundTrip(orig)
+	if err != nil {
+		return nil, err
+	}
+	return compareXml(orig, out)
+}
+
+// roundTrip runs XML through NewFromXml, ToKdl, NewFromKdl and ToXml.
+func roundTrip(orig []byte) ([]byte, error) {
+	doc, err := ko.NewFromXml(bytes.NewReader(orig))
+	if err != nil {
+		return nil, fmt.Errorf("parsing xml: %w", err)
+	}
+	var kdlBuf bytes.Buffer
+	err = doc.ToKdl(&kdlBuf)
+	if err != nil {
+		return nil, fmt.Errorf("writing kdl: %w", err)
+	}
+	doc, err = ko.NewFromKdl(&kdlBuf)
+	if err != nil {
+		return nil, fmt.Errorf("reparsing kdl: %w", err)
+	}
+	var xmlBuf bytes.Buffer
+	err = doc.ToXml(&xmlBuf)
+	if err != nil {
+		return nil, fmt.Errorf("writing xml: %w", err)
+	}
+	return xmlBuf.Bytes(), nil
+}
+
+// xelem is an element of an XML file with the position of its start tag.
+type xelem struct {
+	name     string
+	attrs    []xml.Attr
+	line     int
+	column   int
+	children []*xelem
+	text     []xtext
+	comments []xtext
+}
+
+type xtext struct {
+	value  string
+	line   int
+	column int
+}
+
+// xdoc is an XML file parsed for comparison.
+type xdoc struct {
+	encoding string
+	bom      bool
+	crlf     bool
+	root     *xelem // synthetic parent of the top-level nodes
+}
+
+var encodingRE = regexp.MustCompile(`encoding\s*=\s*["']([^"']*)["']`)
+
+func parseXdoc(b []byte) (*xdoc, error) {
+	d := &xdoc{
+		encoding: "UTF-8",
+		bom:      bytes.HasPrefix(b, []byte("\xEF\xBB\xBF")),
+		crlf:     bytes.Contains(b, []byte("\r\n")),
+		root:     &xelem{},
+	}
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	stack := []*xelem{d.root}
+	for {
+		line, col := dec.InputPos()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decoder.Token: %w", err)
+		}
+		parent := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.ProcInst:
+			if t.Target == "xml" {
+				if m := encodingRE.FindSubmatch(t.Inst); m != nil {
+					d.encoding = string(m[1])
+				}
+			}
+		case xml.StartElement:
+			e := &xelem{name: t.Name.Local, attrs: t.Attr, line: line, column: col}
+			parent.children = append(parent.children, e)
+			stack = append(stack, e)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if strings.TrimSpace(string(t)) != "" {
+				parent.text = append(parent.text, xtext{value: string(t), line: line, column: col})
+			}
+		case xml.Comment:
+			parent.comments = append(parent.comments, xtext{value: string(t), line: line, column: col})
+		}
+	}
+	return d, nil
+}
+
+// compareXml reports what out lost relative to orig.
+func compareXml(orig, out []byte) (*verifyResult, error) {
+	od, err := parseXdoc(orig)
+	if err != nil {
+		return nil, fmt.Errorf("parse original: %w", err)
+	}
+	nd, err := parseXdoc(out)
+	if err != nil {
+		return nil, fmt.Errorf("parse round trip: %w", err)
+	}
+
+	r := &verifyResult{textDiffLine: firstDiffLine(orig, out)}
+	if !strings.EqualFold(od.encoding, nd.encoding) {
+		r.add(lossEncoding, 1, 1, "declared encoding %s became %s", od.encoding, nd.encoding)
+	}
+	if od.bom && !nd.bom {
+		r.add(lossEncoding, 1, 1, "byte order mark dropped")
+	}
+	if od.crlf && !nd.crlf {
+		r.add(lossWhitespace, 1, 1, "CRLF line endings became LF")
+	}
+	r.compareElem(od.root, nd.root)
+
+	sort.SliceStable(r.losses, func(i, j int) bool {
+		if r.losses[i].line != r.losses[j].line {
+			return r.losses[i].line < r.losses[j].line
+		}
+		return r.losses[i].column < r.losses[j].column
+	})
+	return r, nil
+}
+
+func (r *verifyResult) add(kind string, line, col int, format string, args ...any) {
+	r.losses = append(r.losses, loss{kind: kind, line: line, column: col, msg: fmt.Sprintf(format, args...)})
+}
+
+func (r *verifyResult) compareElem(o, n *xelem) {
+	r.compareAttrs(o, n)
+	r.compareText(o, n)
+
+	for i, c := range o.comments {
+		if i >= len(n.comments) || n.comments[i].value != c.value {
+			r.add(lossComment, c.line, c.column, "comment %q dropped", abbreviate(strings.TrimSpace(c.value)))
+		}
+	}
+
+	for i, oc := range o.children {
+		if i >= len(n.children) {
+			r.add(lossElement, oc.line, oc.column, "<%s> dropped", oc.name)
+			continue
+		}
+		nc := n.children[i]
+		if oc.name != nc.name {
+			r.add(lossElement, oc.line, oc.column, "<%s> became <%s>", oc.name, nc.name)
+			continue
+		}
+		r.compareElem(oc, nc)
+	}
+	for _, nc := range n.children[min(len(o.children), len(n.children)):] {
+		r.add(lossElement, o.line, o.column, "<%s> added inside <%s>", nc.name, o.name)
+	}
+}
+
+func (r *verifyResult) compareAttrs(o, n *xelem) {
+	newValues := make(map[string]string, len(n.attrs))
+	for _, a := range n.attrs {
+		newValues[a.Name.Local] = a.Value
+	}
+	var oldNames, newNames []string
+	for _, a := range o.attrs {
+		oldNames = append(oldNames, a.Name.Local)
+		v, ok := newValues[a.Name.Local]
+		switch {
+		case !ok:
+			r.add(lossAttribute, o.line, o.column, "<%s> lost attribute %s=%q", o.name, a.Name.Local, a.Value)
+		case v != a.Value:
+			r.add(lossAttribute, o.line, o.column, "<%s> attribute %s changed from %q to %q", o.name, a.Name.Local, a.Value, v)
+		}
+	}
+	for _, a := range n.attrs {
+		if contains(oldNames, a.Name.Local) {
+			newNames = append(newNames, a.Name.Local)
+		}
+	}
+	if strings.Join(oldNames, ",") != strings.Join(newNames, ",") {
+		r.add(lossOrder, o.line, o.column, "<%s> attributes reordered from %s to %s", o.name, strings.Join(oldNames, ", "), strings.Join(newNames, ", "))
+	}
+}
+
+func (r *verifyResult) compareText(o, n *xelem) {
+	if len(o.text) == 0 {
+		return
+	}
+	var ob, nb strings.Builder
+	for _, t := range o.text {
+		ob.WriteString(t.value)
+	}
+	for _, t := range n.text {
+		nb.WriteString(t.value)
+	}
+	ov, nv := ob.String(), nb.String()
+	pos := o.text[0]
+	switch {
+	case ov == nv:
+	case strings.Join(strings.Fields(ov), " ") == strings.Join(strings.Fields(nv), " "):
+		r.add(lossWhitespace, pos.line, pos.column, "whitespace in the text of <%s> changed", o.name)
+	default:
+		r.add(lossText, pos.line, pos.column, "text of <%s> changed from %q to %q", o.name, abbreviate(ov), abbreviate(nv))
+	}
+}
+
+// firstDiffLine returns the first 1-based line where a and b differ, or 0
+// when they are identical.
+func firstDiffLine(a, b []byte) int {
+	if bytes.Equal(a, b) {
+		return 0
+	}
+	al := bytes.Split(a, []byte("\n"))
+	bl := bytes.Split(b, []byte("\n"))
+	for i := range al {
+		if i >= len(bl) || !bytes.Equal(al[i], bl[i]) {
+			return i + 1
+		}
+	}
+	return len(al) + 1
+}
+
+func abbreviate(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > 60 {
+		return string(r[:57]) + "..."
+	}
+	return s
+}
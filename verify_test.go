@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestVerifyFindsLosses(t *testing.T) {
+	orig := []byte("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n" +
+		"<items>\n" +
+		"\t<!-- guns -->\n" +
+		"\t<item name=\"gunPistol\">\n" +
+		"\t\t<property value=\"gun\" name=\"Tags\"/>\n" +
+		"\t</item>\n" +
+		"</items>\n")
+
+	out, err := roundTrip(orig)
+	if err != nil {
+		t.Fatalf("roundTrip: %v", err)
+	}
+	res, err := compareXml(orig, out)
+	if err != nil {
+		t.Fatalf("compareXml: %v", err)
+	}
+
+	want := []loss{
+		{kind: lossComment, line: 3, column: 2},
+		{kind: lossOrder, line: 5, column: 3},
+	}
+	if len(res.losses) != len(want) {
+		t.Fatalf("got losses %+v, want %d", res.losses, len(want))
+	}
+	for i, w := range want {
+		got := res.losses[i]
+		if got.kind != w.kind || got.line != w.line || got.column != w.column {
+			t.Errorf("loss %d = %+v, want %s at %d:%d", i, got, w.kind, w.line, w.column)
+		}
+	}
+	if res.passes(nil, false) {
+		t.Error("verification passed despite losses")
+	}
+	if !res.passes(map[string]bool{lossComment: true, lossOrder: true}, false) {
+		t.Error("verification failed although every loss was allowed")
+	}
+}
+
+func TestVerifyIdentical(t *testing.T) {
+	orig := []byte("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<items>\n  <item name=\"a\"/>\n</items>")
+	out, err := roundTrip(orig)
+	if err != nil {
+		t.Fatalf("roundTrip: %v", err)
+	}
+	res, err := compareXml(orig, out)
+	if err != nil {
+		t.Fatalf("compareXml: %v", err)
+	}
+	if len(res.losses) != 0 || res.textDiffLine != 0 {
+		t.Errorf("expected a lossless, identical round trip, got %+v\n%s", res, out)
+	}
+}
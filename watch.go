@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/7daystosettle/data-tool/ko"
+)
+
+func runWatch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	flags := addConvertFlags(fs)
+	interval := fs.Duration("interval", 500*time.Millisecond, "how often to poll the source tree")
+	debounce := fs.Duration("debounce", 300*time.Millisecond, "how long the tree must be quiet before reconverting")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s watch [flags] [src_path out_path]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() != 0 && fs.NArg() != 2 {
+		fs.Usage()
+		return errUsage
+	}
+
+	opts, err := flags.options()
+	if err != nil {
+		return err
+	}
+	src, out := opts.project.Source, opts.project.Output
+	if fs.NArg() == 2 {
+		src, out = fs.Arg(0), fs.Arg(1)
+	}
+	if src == "" || out == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	w, err := newWatcher(src, out, opts, *debounce, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Printf("Watching %s (Ctrl+C to stop)\n", w.src)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		w.flush(time.Now())
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			w.poll(now)
+		}
+	}
+}
+
+// watchedFile is the part of a source file's state that tells us it changed.
+type watchedFile struct {
+	modTime time.Time
+	size    int64
+}
+
+// watcher polls a source file or tree and reconverts the files that changed
+// once saves have stopped arriving for the debounce interval.
+type watcher struct {
+	src      string
+	out      string
+	single   bool
+	opts     convertOptions
+	debounce time.Duration
+	log      io.Writer
+
+	seen       map[string]watchedFile
+	pending    map[string]struct{}
+	lastChange time.Time
+}
+
+func newWatcher(src, out string, opts convertOptions, debounce time.Duration, log io.Writer) (*watcher, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, fmt.Errorf("stat input path: %w", err)
+	}
+	w := &watcher{
+		src:      src,
+		out:      out,
+		single:   !info.IsDir(),
+		opts:     opts,
+		debounce: debounce,
+		log:      log,
+		pending:  make(map[string]struct{}),
+	}
+
+	files, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	w.seen = files
+
+	// Only sources that are newer than their output need converting up
+	// front; everything else is already in sync.
+	for rel, f := range files {
+		target, _ := w.target(rel)
+		ti, err := os.Stat(target)
+		if err != nil || ti.ModTime().Before(f.modTime) {
+			w.pending[rel] = struct{}{}
+		}
+	}
+	return w, nil
+}
+
+// scan returns the current state of every convertible source file, keyed by
+// its path relative to the source root.
+func (w *watcher) scan() (map[string]watchedFile, error) {
+	files := make(map[string]watchedFile)
+	if w.single {
+		info, err := os.Stat(w.src)
+		if err != nil {
+			return nil, fmt.Errorf("stat %s: %w", w.src, err)
+		}
+		files[filepath.Base(w.src)] = watchedFile{modTime: info.ModTime(), size: info.Size()}
+		return files, nil
+	}
+
+	outAbs, _ := filepath.Abs(w.out)
+	err := filepath.WalkDir(w.src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// Never treat our own output as input when it lives inside
+			// the source tree.
+			if abs, _ := filepath.Abs(p); p != w.src && abs == outAbs {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		format := formatFromPath(p)
+		if format == "" || (w.opts.from != "" && format != w.opts.from) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(w.src, p)
+		if err != nil {
+			return err
+		}
+		files[rel] = watchedFile{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk %s: %w", w.src, err)
+	}
+	return files, nil
+}
+
+// target returns the output path and style for the source at rel.
+func (w *watcher) target(rel string) (string, ko.Options) {
+	format := w.opts.to
+	if format == "" {
+		format = otherFormat(formatFromPath(rel))
+	}
+	if w.single {
+		return w.out, w.opts.style
+	}
+	outRel, style := w.opts.forSource(w.src, rel, format)
+	return filepath.Join(w.out, outRel), style
+}
+
+// poll rescans the source and queues every file that was added or changed.
+func (w *watcher) poll(now time.Time) {
+	files, err := w.scan()
+	if err != nil {
+		fmt.Fprintf(w.log, "%s: %v\n", w.src, err)
+		return
+	}
+	for rel, f := range files {
+		if old, ok := w.seen[rel]; ok && old == f {
+			continue
+		}
+		w.pending[rel] = struct{}{}
+		w.lastChange = now
+	}
+	w.seen = files
+}
+
+// flush converts the queued files if nothing has changed for the debounce
+// interval. Failures are reported and the file is dropped from the queue; it
+// will be retried on its next save.
+func (w *watcher) flush(now time.Time) {
+	if len(w.pending) == 0 || now.Sub(w.lastChange) < w.debounce {
+		return
+	}
+
+	rels := make([]string, 0, len(w.pending))
+	for rel := range w.pending {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	for _, rel := range rels {
+		delete(w.pending, rel)
+		if _, ok := w.seen[rel]; !ok {
+			continue // removed before we got to it
+		}
+		inFile := w.src
+		if !w.single {
+			inFile = filepath.Join(w.src, rel)
+		}
+		outFile, style := w.target(rel)
+
+		err := os.MkdirAll(filepath.Dir(outFile), 0o755)
+		if err == nil {
+			fileOpts := w.opts
+			fileOpts.from, fileOpts.style = formatFromPath(inFile), style
+			_, err = convert(inFile, outFile, fileOpts)
+		}
+		if errors.Is(err, errSkipped) {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintln(w.log, describeError(inFile, err))
+			continue
+		}
+		fmt.Fprintf(w.log, "%s Converted %s -> %s\n", now.Format("15:04:05"), inFile, outFile)
+	}
+}
+
+// describeError formats err for path in the file:line:column style editors
+// recognise when the error carries a position.
+func describeError(path string, err error) string {
+	var perr *ko.ParseError
+	if errors.As(err, &perr) {
+		if perr.Column > 0 {
+			return fmt.Sprintf("%s:%d:%d: %v", path, perr.Line, perr.Column, perr.Err)
+		}
+		return fmt.Sprintf("%s:%d: %v", path, perr.Line, perr.Err)
+	}
+	return fmt.Sprintf("%s: %v", path, err)
+}
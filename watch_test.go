@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7daystosettle/data-tool/project"
+)
+
+func TestWatcherDebouncesAndReportsErrors(t *testing.T) {
+	src := t.TempDir()
+	out := t.TempDir()
+	mods := filepath.Join(src, "mods")
+	if err := os.Mkdir(mods, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	inFile := filepath.Join(mods, "items.kdl")
+	if err := os.WriteFile(inFile, []byte("items {\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var log bytes.Buffer
+	w, err := newWatcher(src, out, convertOptions{project: &project.Config{}}, time.Second, &log)
+	if err != nil {
+		t.Fatalf("newWatcher: %v", err)
+	}
+	outFile := filepath.Join(out, "mods", "items.xml")
+
+	now := time.Now()
+	w.flush(now)
+	if _, err := os.Stat(outFile); err != nil {
+		t.Fatalf("initial sync did not write %s: %v", outFile, err)
+	}
+
+	if err := os.WriteFile(inFile, []byte("items {\n  item name=\"gunPistol\"\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w.poll(now)
+	w.flush(now.Add(500 * time.Millisecond))
+	if b, _ := os.ReadFile(outFile); strings.Contains(string(b), "gunPistol") {
+		t.Fatalf("converted before the debounce interval elapsed")
+	}
+	w.flush(now.Add(time.Second))
+	if b, _ := os.ReadFile(outFile); !strings.Contains(string(b), "gunPistol") {
+		t.Fatalf("change was not converted:\n%s", b)
+	}
+
+	if err := os.WriteFile(inFile, []byte("items {\n  item name=\"gunPistol\" {\n}\n}}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w.poll(now.Add(2 * time.Second))
+	w.flush(now.Add(4 * time.Second))
+	if !strings.Contains(log.String(), inFile+":4:3:") {
+		t.Errorf("parse error was not reported with its position:\n%s", log.String())
+	}
+}